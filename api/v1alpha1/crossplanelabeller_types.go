@@ -16,10 +16,190 @@ type CrossplaneLabellerSpec struct {
 	// Labels to apply to Crossplane resources
 	Labels map[string]string `json:"labels,omitempty"`
 
+	// LabelMappings copies labels from each namespace onto the resources belonging to it.
+	// Mapped labels take precedence over the static Labels.
+	LabelMappings []LabelMapping `json:"labelMappings,omitempty"`
+
+	// LabelWriteMode selects where labels are written: Metadata writes Kubernetes labels only,
+	// ForProvider writes spec.forProvider.labels so they become GCP labels, and Both writes
+	// both (default: Metadata)
+	// +kubebuilder:validation:Enum=Metadata;ForProvider;Both
+	LabelWriteMode string `json:"labelWriteMode,omitempty"`
+
+	// ResourceDiscovery selects which discovered managed resource kinds are labelled
+	ResourceDiscovery ResourceDiscovery `json:"resourceDiscovery,omitempty"`
+
+	// LabelFields overrides or extends the built-in registry of where each kind keeps its
+	// GCP labels, or marks kinds that cannot be labelled
+	LabelFields []LabelFieldMapping `json:"labelFields,omitempty"`
+
+	// Detectors enables, disables and weighs the detectors that associate resources with
+	// namespaces. Detectors that are not listed run with their default weight.
+	Detectors []DetectorSetting `json:"detectors,omitempty"`
+
+	// ConfidenceThreshold is the confidence, in percent, a resource must exceed to be
+	// attributed to a namespace (default: 30). 0 attributes every resource with any evidence.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	ConfidenceThreshold *int `json:"confidenceThreshold,omitempty"`
+
 	// IntervalSeconds defines how often to reconcile (default: 300)
 	IntervalSeconds int `json:"intervalSeconds,omitempty"`
 }
 
+// LabelMapping maps a namespace label to a resource label
+type LabelMapping struct {
+	// SourceLabel is the label key on the namespace
+	SourceLabel string `json:"sourceLabel"`
+
+	// TargetLabel is the label key to set on the resource (default: SourceLabel)
+	TargetLabel string `json:"targetLabel,omitempty"`
+
+	// DefaultValue is used when the namespace does not have the source label
+	DefaultValue string `json:"defaultValue,omitempty"`
+}
+
+// ResourceDiscovery selects managed resource kinds among the CRDs installed in the cluster.
+// A kind is selected when its API group ends with one of the group suffixes or its CRD is in
+// one of the categories, it matches an include entry (when any are given), and it matches
+// no exclude entry.
+type ResourceDiscovery struct {
+	// GroupSuffixes selects API groups by suffix (default: .gcp.upbound.io when no categories are set)
+	GroupSuffixes []string `json:"groupSuffixes,omitempty"`
+
+	// Categories selects CRDs by category, e.g. managed
+	Categories []string `json:"categories,omitempty"`
+
+	// Include restricts the selection to these kinds
+	Include []ResourceTypeReference `json:"include,omitempty"`
+
+	// Exclude removes these kinds from the selection
+	Exclude []ResourceTypeReference `json:"exclude,omitempty"`
+}
+
+// ResourceTypeReference refers to a managed resource kind, or to a whole API group when
+// the kind is empty
+type ResourceTypeReference struct {
+	// APIGroup is the API group of the kind, e.g. storage.gcp.upbound.io
+	APIGroup string `json:"apiGroup"`
+
+	// Kind is the kind, e.g. Bucket
+	Kind string `json:"kind,omitempty"`
+}
+
+// DeepCopyInto implements the deep copy interface
+func (in *ResourceDiscovery) DeepCopyInto(out *ResourceDiscovery) {
+	*out = *in
+	if in.GroupSuffixes != nil {
+		in, out := &in.GroupSuffixes, &out.GroupSuffixes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Categories != nil {
+		in, out := &in.Categories, &out.Categories
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]ResourceTypeReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]ResourceTypeReference, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// LabelFieldMapping locates the GCP labels of a managed resource kind
+type LabelFieldMapping struct {
+	// APIGroup is the API group of the kind, e.g. sql.gcp.upbound.io
+	APIGroup string `json:"apiGroup"`
+
+	// Version restricts the mapping to one API version (default: all versions)
+	Version string `json:"version,omitempty"`
+
+	// Kind is the kind of the managed resource, e.g. DatabaseInstance
+	Kind string `json:"kind"`
+
+	// Path is the dot-separated location of the labels map relative to spec.forProvider,
+	// e.g. settings.userLabels
+	Path string `json:"path,omitempty"`
+
+	// Unsupported marks the kind as unable to carry GCP labels
+	Unsupported bool `json:"unsupported,omitempty"`
+}
+
+// DetectorSetting tunes one detector
+type DetectorSetting struct {
+	// Name is the name of the detector
+	// +kubebuilder:validation:Enum=claim;name;label;spec-field;network;subnet;flow;secret;external-secret;workload-identity;disk;address;image;reference;cloudsql
+	Name string `json:"name"`
+
+	// Enabled runs the detector (default: true)
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Weight scales the confidence of the detector's evidence, in percent (default: 100).
+	// Evidence is capped at full confidence. 0 keeps the detector running without its
+	// evidence counting.
+	// +kubebuilder:validation:Minimum=0
+	Weight *int `json:"weight,omitempty"`
+}
+
+// DeepCopyInto implements the deep copy interface
+func (in *DetectorSetting) DeepCopyInto(out *DetectorSetting) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Weight != nil {
+		in, out := &in.Weight, &out.Weight
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// SharedResource records a managed resource attributed to more than one namespace
+type SharedResource struct {
+	// APIVersion is the API version of the resource
+	APIVersion string `json:"apiVersion"`
+
+	// Kind is the kind of the resource
+	Kind string `json:"kind"`
+
+	// Name is the name of the resource
+	Name string `json:"name"`
+
+	// Owners are the namespaces the resource is attributed to, most confident first, up
+	// to 10. The resource is labelled for the first owner.
+	Owners []ResourceOwner `json:"owners"`
+
+	// OwnerCount is the number of namespaces the resource is attributed to
+	OwnerCount int `json:"ownerCount"`
+}
+
+// ResourceOwner is a namespace a resource is attributed to
+type ResourceOwner struct {
+	// Namespace is the name of the namespace
+	Namespace string `json:"namespace"`
+
+	// Confidence is the confidence of the attribution, in percent
+	Confidence int `json:"confidence"`
+}
+
+// DeepCopyInto implements the deep copy interface
+func (in *SharedResource) DeepCopyInto(out *SharedResource) {
+	*out = *in
+	if in.Owners != nil {
+		in, out := &in.Owners, &out.Owners
+		*out = make([]ResourceOwner, len(*in))
+		copy(*out, *in)
+	}
+}
+
 // CrossplaneLabellerStatus defines the observed state of CrossplaneLabeller
 type CrossplaneLabellerStatus struct {
 	// LastReconcileTime is the last time resources were reconciled
@@ -28,6 +208,17 @@ type CrossplaneLabellerStatus struct {
 	// ResourcesLabeled indicates the number of resources that were labeled
 	ResourcesLabeled int `json:"resourcesLabeled,omitempty"`
 
+	// SharedResources lists up to 50 of the resources attributed to more than one
+	// namespace, those whose second owner is the most confident first
+	SharedResources []SharedResource `json:"sharedResources,omitempty"`
+
+	// SharedResourceCount is the number of resources attributed to more than one namespace
+	SharedResourceCount int `json:"sharedResourceCount,omitempty"`
+
+	// ExcludedPods counts the pods left out of network matching, by reason: HostNetwork,
+	// Terminal or NodeIP
+	ExcludedPods map[string]int `json:"excludedPods,omitempty"`
+
 	// Conditions represents the latest available observations of the CrossplaneLabeller's state
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
@@ -83,11 +274,48 @@ func (in *CrossplaneLabellerSpec) DeepCopyInto(out *CrossplaneLabellerSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.LabelMappings != nil {
+		in, out := &in.LabelMappings, &out.LabelMappings
+		*out = make([]LabelMapping, len(*in))
+		copy(*out, *in)
+	}
+	in.ResourceDiscovery.DeepCopyInto(&out.ResourceDiscovery)
+	if in.LabelFields != nil {
+		in, out := &in.LabelFields, &out.LabelFields
+		*out = make([]LabelFieldMapping, len(*in))
+		copy(*out, *in)
+	}
+	if in.Detectors != nil {
+		in, out := &in.Detectors, &out.Detectors
+		*out = make([]DetectorSetting, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ConfidenceThreshold != nil {
+		in, out := &in.ConfidenceThreshold, &out.ConfidenceThreshold
+		*out = new(int)
+		**out = **in
+	}
 }
 
 // DeepCopyInto implements the deep copy interface
 func (in *CrossplaneLabellerStatus) DeepCopyInto(out *CrossplaneLabellerStatus) {
 	*out = *in
+	if in.SharedResources != nil {
+		in, out := &in.SharedResources, &out.SharedResources
+		*out = make([]SharedResource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExcludedPods != nil {
+		in, out := &in.ExcludedPods, &out.ExcludedPods
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]metav1.Condition, len(*in))
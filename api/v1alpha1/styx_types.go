@@ -13,8 +13,33 @@ type StyxSpec struct {
 	// IntervalSeconds defines how often to reconcile (default: 300)
 	IntervalSeconds int `json:"intervalSeconds,omitempty"`
 
-	// IncludeChildResources determines whether to label child resources
+	// IncludeChildResources determines whether to label child resources: the claims and
+	// composites of a namespace with everything they compose, and resources owned by
+	// labeled resources
 	IncludeChildResources bool `json:"includeChildResources,omitempty"`
+
+	// LabelWriteMode selects where labels are written: Metadata writes Kubernetes labels only,
+	// ForProvider writes spec.forProvider.labels so they become GCP labels, and Both writes
+	// both (default: Metadata)
+	// +kubebuilder:validation:Enum=Metadata;ForProvider;Both
+	LabelWriteMode string `json:"labelWriteMode,omitempty"`
+
+	// ResourceDiscovery selects which discovered managed resource kinds are labelled
+	ResourceDiscovery ResourceDiscovery `json:"resourceDiscovery,omitempty"`
+
+	// LabelFields overrides or extends the built-in registry of where each kind keeps its
+	// GCP labels, or marks kinds that cannot be labelled
+	LabelFields []LabelFieldMapping `json:"labelFields,omitempty"`
+
+	// Detectors enables, disables and weighs the detectors that associate resources with
+	// namespaces. Detectors that are not listed run with their default weight.
+	Detectors []DetectorSetting `json:"detectors,omitempty"`
+
+	// ConfidenceThreshold is the confidence, in percent, a resource must exceed to be
+	// attributed to a namespace (default: 30). 0 attributes every resource with any evidence.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	ConfidenceThreshold *int `json:"confidenceThreshold,omitempty"`
 }
 
 // StyxStatus defines the observed state of Styx
@@ -24,6 +49,20 @@ type StyxStatus struct {
 
 	// ResourceCounts tracks the number of resources by type
 	ResourceCounts map[string]int `json:"resourceCounts,omitempty"`
+
+	// SharedResources lists up to 50 of the resources attributed to more than one
+	// namespace, those whose second owner is the most confident first
+	SharedResources []SharedResource `json:"sharedResources,omitempty"`
+
+	// SharedResourceCount is the number of resources attributed to more than one namespace
+	SharedResourceCount int `json:"sharedResourceCount,omitempty"`
+
+	// ExcludedPods counts the pods left out of network matching, by reason: HostNetwork,
+	// Terminal or NodeIP
+	ExcludedPods map[string]int `json:"excludedPods,omitempty"`
+
+	// Conditions represents the latest available observations of the Styx's state
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -69,6 +108,24 @@ func (in *Styx) DeepCopyInto(out *Styx) {
 // DeepCopyInto implements the deep copy interface
 func (in *StyxSpec) DeepCopyInto(out *StyxSpec) {
 	*out = *in
+	in.ResourceDiscovery.DeepCopyInto(&out.ResourceDiscovery)
+	if in.LabelFields != nil {
+		in, out := &in.LabelFields, &out.LabelFields
+		*out = make([]LabelFieldMapping, len(*in))
+		copy(*out, *in)
+	}
+	if in.Detectors != nil {
+		in, out := &in.Detectors, &out.Detectors
+		*out = make([]DetectorSetting, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ConfidenceThreshold != nil {
+		in, out := &in.ConfidenceThreshold, &out.ConfidenceThreshold
+		*out = new(int)
+		**out = **in
+	}
 }
 
 // DeepCopyInto implements the deep copy interface
@@ -81,6 +138,27 @@ func (in *StyxStatus) DeepCopyInto(out *StyxStatus) {
 			(*out)[key] = val
 		}
 	}
+	if in.SharedResources != nil {
+		in, out := &in.SharedResources, &out.SharedResources
+		*out = make([]SharedResource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExcludedPods != nil {
+		in, out := &in.ExcludedPods, &out.ExcludedPods
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,62 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/deen/styx/pkg/crossplane"
+)
+
+// NewCrossplaneHandler creates a Crossplane handler for the project in GCP_PROJECT_ID and
+// adds it to the manager, which runs its resource discovery and informer cache. Create
+// one handler and share it between reconcilers so the cache is only built once.
+func NewCrossplaneHandler(mgr ctrl.Manager) (*crossplane.CrossplaneHandler, error) {
+	projectID := os.Getenv("GCP_PROJECT_ID")
+	if projectID == "" {
+		return nil, fmt.Errorf("GCP_PROJECT_ID environment variable is required")
+	}
+
+	crossplaneClient, err := crossplane.NewCrossplaneHandler(projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Crossplane client: %v", err)
+	}
+
+	// Keep the managed resource types and cache up to date while the manager runs
+	if err := mgr.Add(crossplaneClient); err != nil {
+		return nil, fmt.Errorf("failed to add Crossplane client to manager: %v", err)
+	}
+
+	// Record which pod holds each IP and when, so network evidence is not credited to a
+	// namespace that received a recycled IP
+	podInformer, err := mgr.GetCache().GetInformer(context.Background(), &corev1.Pod{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod informer: %v", err)
+	}
+	registration, err := podInformer.AddEventHandler(crossplaneClient.PodEventHandler())
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch pod IPs: %v", err)
+	}
+	crossplaneClient.SetPodsSynced(registration.HasSynced)
+
+	return crossplaneClient, nil
+}
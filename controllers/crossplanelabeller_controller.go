@@ -18,18 +18,27 @@ package controllers
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
-	"os"
+	"reflect"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	crossplanev1alpha1 "github.com/deen/styx/api/v1alpha1"
 	"github.com/deen/styx/pkg/crossplane"
@@ -40,7 +49,7 @@ import (
 type CrossplaneLabellerReconciler struct {
 	client.Client
 	Scheme           *runtime.Scheme
-	crossplaneClient *crossplane.CrossplaneHandler
+	CrossplaneClient *crossplane.CrossplaneHandler
 }
 
 //+kubebuilder:rbac:groups=crossplane.styx.io,resources=crossplanelabellers,verbs=get;list;watch;create;update;patch;delete
@@ -48,7 +57,16 @@ type CrossplaneLabellerReconciler struct {
 //+kubebuilder:rbac:groups=crossplane.styx.io,resources=crossplanelabellers/finalizers,verbs=update
 //+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
-//+kubebuilder:rbac:groups=compute.gcp.upbound.io;storage.gcp.upbound.io;sql.gcp.upbound.io;redis.gcp.upbound.io;bigtable.gcp.upbound.io;spanner.gcp.upbound.io;pubsub.gcp.upbound.io;cloudfunctions.gcp.upbound.io;kms.gcp.upbound.io;cloudscheduler.gcp.upbound.io;iam.gcp.upbound.io;cloudplatform.gcp.upbound.io,resources=*,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims;persistentvolumes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=external-secrets.io,resources=externalsecrets;secretstores;clustersecretstores,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=selfsubjectaccessreviews,verbs=create
+//+kubebuilder:rbac:groups=compute.gcp.upbound.io;storage.gcp.upbound.io;sql.gcp.upbound.io;redis.gcp.upbound.io;bigtable.gcp.upbound.io;spanner.gcp.upbound.io;pubsub.gcp.upbound.io;cloudfunctions.gcp.upbound.io;kms.gcp.upbound.io;cloudscheduler.gcp.upbound.io;iam.gcp.upbound.io;cloudplatform.gcp.upbound.io;artifact.gcp.upbound.io;secretmanager.gcp.upbound.io;container.gcp.upbound.io,resources=*,verbs=get;list;watch;update;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -106,91 +124,121 @@ func (r *CrossplaneLabellerReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, err
 	}
 
-	// Ensure we have a Crossplane client
-	if r.crossplaneClient == nil {
-		projectID := os.Getenv("GCP_PROJECT_ID")
-		if projectID == "" {
-			err := fmt.Errorf("GCP_PROJECT_ID environment variable is required")
-			logger.Error(err, "Missing required environment variable")
-			r.updateCondition(
-				&crossplaneLabeller,
-				"Ready",
-				metav1.ConditionFalse,
-				"ConfigurationError",
-				"GCP_PROJECT_ID environment variable is missing",
-			)
-			if updateErr := r.Status().Update(ctx, &crossplaneLabeller); updateErr != nil {
-				logger.Error(updateErr, "Failed to update status after configuration error")
-			}
-			return ctrl.Result{}, err
-		}
+	// Resolve the labels each namespace contributes to its resources
+	namespaceLabels := make(map[string]map[string]string, len(namespaces))
+	for i := range namespaces {
+		namespaceLabels[namespaces[i].Name] = resolveNamespaceLabels(&namespaces[i], &crossplaneLabeller.Spec)
+	}
 
-		r.crossplaneClient, err = crossplane.NewCrossplaneHandler(projectID)
-		if err != nil {
-			logger.Error(err, "Failed to create Crossplane client")
-			r.updateCondition(
-				&crossplaneLabeller,
-				"Ready",
-				metav1.ConditionFalse,
-				"CrossplaneClientInitFailed",
-				fmt.Sprintf("Failed to initialize Crossplane client: %v", err),
-			)
-			if updateErr := r.Status().Update(ctx, &crossplaneLabeller); updateErr != nil {
-				logger.Error(updateErr, "Failed to update status after client init error")
-			}
-			return ctrl.Result{}, err
-		}
+	// The labels the labeller writes are not evidence, or a wrong attribution would
+	// confirm itself
+	findOptions := crossplane.FindOptions{
+		ResourceTypes:    resourceTypeFilter(&crossplaneLabeller.Spec.ResourceDiscovery),
+		DetectorSettings: detectorSettings(crossplaneLabeller.Spec.Detectors),
+		Threshold:        fromPercent(crossplaneLabeller.Spec.ConfidenceThreshold),
+		IgnoredLabels:    labelKeys(namespaceLabels),
 	}
 
-	// Process resources and update labels
-	resourcesLabeled := 0
-	var labelErrors []string
+	labelOptions := crossplane.LabelOptions{
+		Mode:   crossplane.LabelWriteMode(crossplaneLabeller.Spec.LabelWriteMode),
+		Fields: labelFieldRegistry(crossplaneLabeller.Spec.LabelFields),
+	}
+
+	// Group the selected pods per namespace, so each namespace is searched once
+	// rather than once per pod
+	podsByNamespace := make(map[string][]corev1.Pod)
 	for _, pod := range pods {
-		// Get all pod IPs
-		var podIPs []string
-		for _, podIP := range pod.Status.PodIPs {
-			podIPs = append(podIPs, podIP.IP)
-		}
-		if pod.Status.PodIP != "" && len(podIPs) == 0 {
-			podIPs = append(podIPs, pod.Status.PodIP)
+		podsByNamespace[pod.Namespace] = append(podsByNamespace[pod.Namespace], pod)
+	}
+
+	// Attribute each resource to the namespaces it matches
+	ownership := newResourceOwnership()
+	excludedPods := make(map[string]int)
+	var labelErrors []string
+
+	// Node IPs are shared by hostNetwork pods and never identify a namespace
+	nodeIPs, err := fetchNodeIPs(ctx, r.Client)
+	if err != nil {
+		labelErrors = append(labelErrors, err.Error())
+		logger.Error(err, "Failed to fetch node addresses")
+	}
+
+	// Every selected namespace is searched, including those without selected pods: their
+	// claims, volume claims, Services, Ingresses and ExternalSecrets are still evidence
+	for i := range namespaces {
+		namespace := namespaces[i].Name
+		nsCtx, err := fetchNamespaceContext(ctx, r.Client, namespace, podsByNamespace[namespace], nodeIPs)
+		if err != nil {
+			msg := fmt.Sprintf("Namespace %s: %v", namespace, err)
+			labelErrors = append(labelErrors, msg)
+			logger.Error(err, "Failed to fetch namespace workloads",
+				"namespace", namespace)
+			continue
 		}
 
-		// Find Crossplane resources associated with this pod and namespace
-		resources, err := r.crossplaneClient.FindCrossplaneResourcesForNamespaceWithNetworking(
+		// Find Crossplane resources associated with the namespace and its pods
+		resources, err := r.CrossplaneClient.FindCrossplaneResourcesForNamespaceContext(
 			ctx,
-			pod.Namespace,
-			podIPs,
+			nsCtx,
+			findOptions,
 		)
 		if err != nil {
-			msg := fmt.Sprintf("Pod %s/%s: %v", pod.Namespace, pod.Name, err)
+			msg := fmt.Sprintf("Namespace %s: %v", namespace, err)
 			labelErrors = append(labelErrors, msg)
 			logger.Error(err, "Failed to find Crossplane resources",
-				"pod", pod.Name,
-				"namespace", pod.Namespace)
+				"namespace", namespace)
 			continue
 		}
 
-		// Apply labels to each resource
 		for _, resourceMatch := range resources {
-			if err := r.crossplaneClient.ApplyLabelsToResource(
-				ctx,
-				resourceMatch.Resource,
-				crossplaneLabeller.Spec.Labels,
-			); err != nil {
-				msg := fmt.Sprintf("Resource %s/%s: %v", resourceMatch.Resource.GetKind(), resourceMatch.Resource.GetName(), err)
-				labelErrors = append(labelErrors, msg)
-				logger.Error(err, "Failed to apply labels to resource",
-					"resource", fmt.Sprintf("%s/%s", resourceMatch.Resource.GetKind(), resourceMatch.Resource.GetName()))
+			ownership.add(resourceMatch.Resource, namespace, resourceMatch.ConfidenceScore, resourceMatch.MatchReasons)
+		}
+		addExclusions(excludedPods, nsCtx)
+	}
+
+	// Label each resource once, for the namespace it matches with the highest confidence
+	resourcesLabeled := 0
+	for _, owned := range ownership.list() {
+		owner := owned.primary()
+		resource := owned.resource
+
+		// Kinds that cannot carry GCP labels are skipped rather than reported as errors
+		if !labelOptions.Labellable(resource.GroupVersionKind()) {
+			logger.V(1).Info("Skipping resource kind without GCP labels",
+				"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()))
+			continue
+		}
+
+		if err := r.CrossplaneClient.ApplyLabelsToResource(
+			ctx,
+			resource,
+			namespaceLabels[owner.namespace],
+			labelOptions,
+		); err != nil {
+			// A resource missing the parent of its label field, such as a node pool without
+			// nodeConfig, cannot take GCP labels yet and is skipped rather than reported
+			if goerrors.Is(err, crossplane.ErrUnlabellable) {
+				logger.V(1).Info("Skipping resource without its GCP label field",
+					"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()),
+					"reason", err.Error())
 				continue
 			}
-
-			logger.Info("Applied labels to resource",
-				"resource", fmt.Sprintf("%s/%s", resourceMatch.Resource.GetKind(), resourceMatch.Resource.GetName()),
-				"confidence", resourceMatch.ConfidenceScore,
-				"matchReasons", resourceMatch.MatchReasons)
-			resourcesLabeled++
+			msg := fmt.Sprintf("Resource %s/%s: %v", resource.GetKind(), resource.GetName(), err)
+			labelErrors = append(labelErrors, msg)
+			logger.Error(err, "Failed to apply labels to resource",
+				"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()))
+			continue
 		}
+
+		logger.Info("Applied labels to resource",
+			"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()),
+			"namespace", owner.namespace,
+			"confidence", owner.confidence,
+			"matchReasons", owner.reasons)
+		resourcesLabeled++
 	}
+	crossplaneLabeller.Status.SharedResources, crossplaneLabeller.Status.SharedResourceCount = ownership.shared()
+	crossplaneLabeller.Status.ExcludedPods = excludedPods
 
 	// Update status
 	if err := r.updateStatus(ctx, &crossplaneLabeller, resourcesLabeled, logger); err != nil {
@@ -325,6 +373,85 @@ func (r *CrossplaneLabellerReconciler) fetchPods(
 	return allPods, nil
 }
 
+// resolveNamespaceLabels returns the labels to apply to resources belonging to a namespace:
+// the static spec labels overlaid with the namespace labels selected by the label mappings
+func resolveNamespaceLabels(
+	ns *corev1.Namespace,
+	spec *crossplanev1alpha1.CrossplaneLabellerSpec,
+) map[string]string {
+	labels := make(map[string]string, len(spec.Labels)+len(spec.LabelMappings))
+	for key, value := range spec.Labels {
+		labels[key] = value
+	}
+
+	for _, mapping := range spec.LabelMappings {
+		targetLabel := mapping.TargetLabel
+		if targetLabel == "" {
+			targetLabel = mapping.SourceLabel
+		}
+
+		if value, ok := ns.Labels[mapping.SourceLabel]; ok {
+			labels[targetLabel] = value
+		} else if mapping.DefaultValue != "" {
+			labels[targetLabel] = mapping.DefaultValue
+		}
+	}
+
+	return labels
+}
+
+// resourceTypeFilter converts the resource discovery settings of the spec into a filter
+func resourceTypeFilter(discovery *crossplanev1alpha1.ResourceDiscovery) crossplane.ResourceTypeFilter {
+	filter := crossplane.ResourceTypeFilter{
+		GroupSuffixes: discovery.GroupSuffixes,
+		Categories:    discovery.Categories,
+	}
+	for _, ref := range discovery.Include {
+		filter.Include = append(filter.Include, schema.GroupKind{Group: ref.APIGroup, Kind: ref.Kind})
+	}
+	for _, ref := range discovery.Exclude {
+		filter.Exclude = append(filter.Exclude, schema.GroupKind{Group: ref.APIGroup, Kind: ref.Kind})
+	}
+	return filter
+}
+
+// labelFieldRegistry returns the built-in label field registry extended with the
+// label fields declared in the spec
+func labelFieldRegistry(mappings []crossplanev1alpha1.LabelFieldMapping) *crossplane.LabelFieldRegistry {
+	registry := crossplane.DefaultLabelFieldRegistry()
+	for _, mapping := range mappings {
+		gvk := schema.GroupVersionKind{Group: mapping.APIGroup, Version: mapping.Version, Kind: mapping.Kind}
+		field := crossplane.LabelField{Path: []string{"labels"}, Unsupported: mapping.Unsupported}
+		if mapping.Path != "" {
+			field.Path = strings.Split(mapping.Path, ".")
+		}
+		registry.Register(gvk, field)
+	}
+	return registry
+}
+
+// detectorSettings converts the detector settings of the spec, whose weights are in percent
+func detectorSettings(settings []crossplanev1alpha1.DetectorSetting) map[string]crossplane.DetectorSettings {
+	converted := make(map[string]crossplane.DetectorSettings, len(settings))
+	for _, setting := range settings {
+		converted[setting.Name] = crossplane.DetectorSettings{
+			Disabled: setting.Enabled != nil && !*setting.Enabled,
+			Weight:   fromPercent(setting.Weight),
+		}
+	}
+	return converted
+}
+
+// fromPercent converts an optional percentage of the spec to a fraction, leaving it unset
+// when the spec does not set it
+func fromPercent(percent *int) *float64 {
+	if percent == nil {
+		return nil
+	}
+	fraction := float64(*percent) / 100
+	return &fraction
+}
+
 // updateCondition updates a condition in the CrossplaneLabeller status
 func (r *CrossplaneLabellerReconciler) updateCondition(
 	crossplaneLabeller *crossplanev1alpha1.CrossplaneLabeller,
@@ -389,19 +516,119 @@ func (r *CrossplaneLabellerReconciler) updateStatus(
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *CrossplaneLabellerReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	// Initialize the Crossplane client
-	projectID := os.Getenv("GCP_PROJECT_ID")
-	if projectID == "" {
-		return fmt.Errorf("GCP_PROJECT_ID environment variable is required")
+	// Create a Crossplane client unless one is shared with other reconcilers
+	if r.CrossplaneClient == nil {
+		crossplaneClient, err := NewCrossplaneHandler(mgr)
+		if err != nil {
+			return err
+		}
+		r.CrossplaneClient = crossplaneClient
+	}
+
+	// Namespace, pod and managed resource changes are mapped back to the labellers that
+	// select them; the periodic requeue remains as a safety net for missed events. Status
+	// updates do not change the generation, so writing the status does not requeue.
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&crossplanev1alpha1.CrossplaneLabeller{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(r.labellersForNamespace),
+		).
+		Watches(
+			&corev1.Pod{},
+			handler.EnqueueRequestsFromMapFunc(r.labellersForPod),
+			builder.WithPredicates(podNetworkChanged()),
+		).
+		WatchesRawSource(
+			&source.Channel{Source: r.CrossplaneClient.Subscribe()},
+			handler.EnqueueRequestsFromMapFunc(r.labellersForResource),
+		).
+		Complete(r)
+}
+
+// labellersForNamespace returns a request for each labeller whose namespace selector
+// matches the namespace
+func (r *CrossplaneLabellerReconciler) labellersForNamespace(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.labellersMatching(ctx, func(labeller *crossplanev1alpha1.CrossplaneLabeller) bool {
+		return selectorMatches(labeller.Spec.NamespaceSelector, obj.GetName())
+	})
+}
+
+// labellersForPod returns a request for each labeller whose namespace and pod selectors
+// match the pod
+func (r *CrossplaneLabellerReconciler) labellersForPod(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.labellersMatching(ctx, func(labeller *crossplanev1alpha1.CrossplaneLabeller) bool {
+		return selectorMatches(labeller.Spec.NamespaceSelector, obj.GetNamespace()) &&
+			selectorMatches(labeller.Spec.PodSelector, obj.GetName())
+	})
+}
+
+// labellersForResource returns a request for each labeller whose resource discovery
+// settings select the kind of the managed resource
+func (r *CrossplaneLabellerReconciler) labellersForResource(ctx context.Context, obj client.Object) []reconcile.Request {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	return r.labellersMatching(ctx, func(labeller *crossplanev1alpha1.CrossplaneLabeller) bool {
+		filter := resourceTypeFilter(&labeller.Spec.ResourceDiscovery)
+		for _, t := range r.CrossplaneClient.ManagedResourceTypes(ctx, filter) {
+			if t.GVR.Group == gvk.Group && t.Kind == gvk.Kind {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// labellersMatching returns a request for each labeller accepted by the match function
+func (r *CrossplaneLabellerReconciler) labellersMatching(
+	ctx context.Context,
+	match func(*crossplanev1alpha1.CrossplaneLabeller) bool,
+) []reconcile.Request {
+	var labellers crossplanev1alpha1.CrossplaneLabellerList
+	if err := r.List(ctx, &labellers); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list CrossplaneLabellers")
+		return nil
 	}
 
-	crossplaneClient, err := crossplane.NewCrossplaneHandler(projectID)
+	var requests []reconcile.Request
+	for i := range labellers.Items {
+		if match(&labellers.Items[i]) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: client.ObjectKeyFromObject(&labellers.Items[i]),
+			})
+		}
+	}
+	return requests
+}
+
+// selectorMatches reports whether a name matches a regex selector; an empty selector
+// matches everything and an invalid one matches nothing
+func selectorMatches(selector, name string) bool {
+	if selector == "" {
+		return true
+	}
+	pattern, err := regexp.Compile(selector)
 	if err != nil {
-		return fmt.Errorf("failed to create Crossplane client: %v", err)
+		return false
 	}
-	r.crossplaneClient = crossplaneClient
+	return pattern.MatchString(name)
+}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&crossplanev1alpha1.CrossplaneLabeller{}).
-		Complete(r)
+// podNetworkChanged filters pod updates down to the ones that change the pod IPs
+// used for network-based detection, including pods turning terminal
+func podNetworkChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldPod, ok := e.ObjectOld.(*corev1.Pod)
+			if !ok {
+				return true
+			}
+			newPod, ok := e.ObjectNew.(*corev1.Pod)
+			if !ok {
+				return true
+			}
+			return oldPod.Status.PodIP != newPod.Status.PodIP ||
+				oldPod.Status.Phase != newPod.Status.Phase ||
+				!reflect.DeepEqual(oldPod.Status.PodIPs, newPod.Status.PodIPs)
+		},
+	}
 }
@@ -3,7 +3,6 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"os"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -18,7 +17,7 @@ import (
 type GCPResourceReconciler struct {
 	client.Client
 	Scheme           *runtime.Scheme
-	crossplaneClient *crossplane.CrossplaneHandler
+	CrossplaneClient *crossplane.CrossplaneHandler
 }
 
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
@@ -44,7 +43,7 @@ func (r *GCPResourceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 
 	// Find Crossplane resources for this workload
-	crossplaneResources, err := r.crossplaneClient.FindCrossplaneResourcesForWorkload(ctx, workloadName)
+	crossplaneResources, err := r.CrossplaneClient.FindCrossplaneResourcesForWorkload(ctx, workloadName)
 	if err != nil {
 		log.Error(err, "failed to find Crossplane resources", "workload", workloadName)
 		return ctrl.Result{}, err
@@ -69,7 +68,7 @@ func (r *GCPResourceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			}
 		}
 
-		if err := r.crossplaneClient.ApplyLabelsToResource(ctx, resource, labels); err != nil {
+		if err := r.CrossplaneClient.ApplyLabelsToResource(ctx, resource, labels, crossplane.LabelOptions{}); err != nil {
 			log.Error(err, "failed to update Crossplane resource labels",
 				"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()))
 			return ctrl.Result{}, err
@@ -85,17 +84,14 @@ func (r *GCPResourceReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 // SetupWithManager sets up the controller with the Manager
 func (r *GCPResourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	// Initialize the Crossplane client
-	projectID := os.Getenv("GCP_PROJECT_ID")
-	if projectID == "" {
-		return fmt.Errorf("GCP_PROJECT_ID environment variable is required")
-	}
-
-	crossplaneClient, err := crossplane.NewCrossplaneHandler(projectID)
-	if err != nil {
-		return fmt.Errorf("failed to create Crossplane client: %v", err)
+	// Create a Crossplane client unless one is shared with other reconcilers
+	if r.CrossplaneClient == nil {
+		crossplaneClient, err := NewCrossplaneHandler(mgr)
+		if err != nil {
+			return err
+		}
+		r.CrossplaneClient = crossplaneClient
 	}
-	r.crossplaneClient = crossplaneClient
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Pod{}).
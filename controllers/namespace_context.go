@@ -0,0 +1,117 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/deen/styx/pkg/crossplane"
+)
+
+// fetchNodeIPs returns the internal and external addresses of the cluster's nodes, which
+// hostNetwork pods share and which must not be matched to a namespace
+func fetchNodeIPs(ctx context.Context, c client.Client) ([]string, error) {
+	var nodes corev1.NodeList
+	if err := c.List(ctx, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to fetch nodes: %v", err)
+	}
+
+	var ips []string
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP || address.Type == corev1.NodeExternalIP {
+				ips = append(ips, address.Address)
+			}
+		}
+	}
+	return ips, nil
+}
+
+// addExclusions adds the pods a namespace left out of network matching to the counts
+// reported in status
+func addExclusions(counts map[string]int, nsCtx *crossplane.NamespaceContext) {
+	for reason, count := range nsCtx.ExcludedPods() {
+		counts[reason] += count
+	}
+}
+
+// fetchNamespaceContext collects the workload state of a namespace that resources are
+// matched against, for the given selected pods of the namespace
+func fetchNamespaceContext(
+	ctx context.Context,
+	c client.Client,
+	namespace string,
+	pods []corev1.Pod,
+	nodeIPs []string,
+) (*crossplane.NamespaceContext, error) {
+	nsCtx := &crossplane.NamespaceContext{Namespace: namespace, Pods: pods, NodeIPs: nodeIPs}
+
+	// Service accounts link the pods to their GCP identities through Workload Identity
+	var serviceAccounts corev1.ServiceAccountList
+	if err := c.List(ctx, &serviceAccounts, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to fetch service accounts: %v", err)
+	}
+	nsCtx.ServiceAccounts = serviceAccounts.Items
+
+	// Claims and the volumes bound to them link the namespace to its disks
+	var claims corev1.PersistentVolumeClaimList
+	if err := c.List(ctx, &claims, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to fetch persistent volume claims: %v", err)
+	}
+	nsCtx.PersistentVolumeClaims = claims.Items
+	for _, claim := range claims.Items {
+		if claim.Spec.VolumeName == "" {
+			continue
+		}
+		var volume corev1.PersistentVolume
+		if err := c.Get(ctx, client.ObjectKey{Name: claim.Spec.VolumeName}, &volume); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to fetch persistent volume %s: %v", claim.Spec.VolumeName, err)
+		}
+		nsCtx.PersistentVolumes = append(nsCtx.PersistentVolumes, volume)
+	}
+
+	// Services and Ingresses link the namespace to its reserved addresses
+	var services corev1.ServiceList
+	if err := c.List(ctx, &services, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to fetch services: %v", err)
+	}
+	nsCtx.Services = services.Items
+
+	var ingresses networkingv1.IngressList
+	if err := c.List(ctx, &ingresses, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to fetch ingresses: %v", err)
+	}
+	nsCtx.Ingresses = ingresses.Items
+
+	// ConfigMaps hold the configuration naming the resources the workloads use
+	var configMaps corev1.ConfigMapList
+	if err := c.List(ctx, &configMaps, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to fetch config maps: %v", err)
+	}
+	nsCtx.ConfigMaps = configMaps.Items
+
+	return nsCtx, nil
+}
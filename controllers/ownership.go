@@ -0,0 +1,159 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"math"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	crossplanev1alpha1 "github.com/deen/styx/api/v1alpha1"
+)
+
+const (
+	// maxSharedResources is how many shared resources are listed in the status, so it
+	// stays small when many resources match several namespaces
+	maxSharedResources = 50
+
+	// maxSharedResourceOwners is how many owners of a shared resource are listed
+	maxSharedResourceOwners = 10
+)
+
+// resourceOwner is a namespace a resource was attributed to during a reconcile
+type resourceOwner struct {
+	namespace  string
+	confidence float64
+	reasons    []string
+}
+
+// ownedResource is a resource with the namespaces it was attributed to, most confident first
+type ownedResource struct {
+	resource unstructured.Unstructured
+	owners   []resourceOwner
+}
+
+// primary returns the owner the resource is labelled for
+func (r *ownedResource) primary() resourceOwner {
+	return r.owners[0]
+}
+
+// resourceOwnership collects the namespaces each resource is attributed to, so a resource
+// matching several namespaces is labelled consistently for the most confident one and
+// reported as shared
+type resourceOwnership struct {
+	order     []string
+	resources map[string]*ownedResource
+}
+
+// newResourceOwnership creates an empty resource ownership
+func newResourceOwnership() *resourceOwnership {
+	return &resourceOwnership{resources: make(map[string]*ownedResource)}
+}
+
+// add attributes a resource to a namespace. A namespace that already owns the resource
+// keeps its highest confidence.
+func (o *resourceOwnership) add(resource unstructured.Unstructured, namespace string, confidence float64, reasons []string) {
+	key := string(resource.GetUID())
+	owned, ok := o.resources[key]
+	if !ok {
+		owned = &ownedResource{resource: resource}
+		o.resources[key] = owned
+		o.order = append(o.order, key)
+	}
+
+	for i := range owned.owners {
+		if owned.owners[i].namespace != namespace {
+			continue
+		}
+		if confidence > owned.owners[i].confidence {
+			owned.owners[i] = resourceOwner{namespace: namespace, confidence: confidence, reasons: reasons}
+		}
+		sortOwners(owned.owners)
+		return
+	}
+
+	owned.owners = append(owned.owners, resourceOwner{namespace: namespace, confidence: confidence, reasons: reasons})
+	sortOwners(owned.owners)
+}
+
+// get returns an owned resource by UID
+func (o *resourceOwnership) get(uid string) (*ownedResource, bool) {
+	owned, ok := o.resources[uid]
+	return owned, ok
+}
+
+// list returns the owned resources in the order they were first attributed
+func (o *resourceOwnership) list() []*ownedResource {
+	owned := make([]*ownedResource, 0, len(o.order))
+	for _, key := range o.order {
+		owned = append(owned, o.resources[key])
+	}
+	return owned
+}
+
+// shared returns the resources attributed to more than one namespace, and how many there
+// are. The listed resources are capped at maxSharedResources, most contested first: those
+// whose second owner is the most confident.
+func (o *resourceOwnership) shared() ([]crossplanev1alpha1.SharedResource, int) {
+	var contested []*ownedResource
+	for _, owned := range o.list() {
+		if len(owned.owners) >= 2 {
+			contested = append(contested, owned)
+		}
+	}
+	sort.SliceStable(contested, func(i, j int) bool {
+		return contested[i].owners[1].confidence > contested[j].owners[1].confidence
+	})
+
+	total := len(contested)
+	if len(contested) > maxSharedResources {
+		contested = contested[:maxSharedResources]
+	}
+
+	var shared []crossplanev1alpha1.SharedResource
+	for _, owned := range contested {
+		entry := crossplanev1alpha1.SharedResource{
+			APIVersion: owned.resource.GetAPIVersion(),
+			Kind:       owned.resource.GetKind(),
+			Name:       owned.resource.GetName(),
+			OwnerCount: len(owned.owners),
+		}
+		for i, owner := range owned.owners {
+			if i == maxSharedResourceOwners {
+				break
+			}
+			entry.Owners = append(entry.Owners, crossplanev1alpha1.ResourceOwner{
+				Namespace:  owner.namespace,
+				Confidence: int(math.Round(owner.confidence * 100)),
+			})
+		}
+		shared = append(shared, entry)
+	}
+	return shared, total
+}
+
+// sortOwners sorts owners by confidence, breaking ties by namespace so the primary owner
+// does not depend on the order namespaces were processed in
+func sortOwners(owners []resourceOwner) {
+	sort.SliceStable(owners, func(i, j int) bool {
+		if owners[i].confidence != owners[j].confidence {
+			return owners[i].confidence > owners[j].confidence
+		}
+		return owners[i].namespace < owners[j].namespace
+	})
+}
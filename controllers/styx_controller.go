@@ -0,0 +1,445 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	crossplanev1alpha1 "github.com/deen/styx/api/v1alpha1"
+	"github.com/deen/styx/pkg/crossplane"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// namespaceLabelKey is the label Styx uses to record which namespace a resource belongs to
+const namespaceLabelKey = "kubernetes-namespace"
+
+// StyxReconciler reconciles a Styx object
+type StyxReconciler struct {
+	client.Client
+	Scheme           *runtime.Scheme
+	CrossplaneClient *crossplane.CrossplaneHandler
+}
+
+//+kubebuilder:rbac:groups=crossplane.styx.io,resources=styxs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=crossplane.styx.io,resources=styxs/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=crossplane.styx.io,resources=styxs/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims;persistentvolumes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=external-secrets.io,resources=externalsecrets;secretstores;clustersecretstores,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=selfsubjectaccessreviews,verbs=create
+
+// Reconcile selects the namespaces matching the Styx selector, finds the Crossplane
+// managed resources associated with each of them and labels those resources with
+// the labels of the namespace they belong to.
+func (r *StyxReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("Reconciling Styx", "name", req.Name, "namespace", req.Namespace)
+
+	// Fetch the Styx instance
+	var styx crossplanev1alpha1.Styx
+	if err := r.Get(ctx, req.NamespacedName, &styx); err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Return and don't requeue
+			logger.Info("Styx resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		logger.Error(err, "Failed to get Styx")
+		return ctrl.Result{}, err
+	}
+
+	// Get namespaces to process
+	namespaces, err := r.fetchNamespaces(ctx, &styx, logger)
+	if err != nil {
+		logger.Error(err, "Failed to fetch namespaces")
+		r.updateCondition(
+			&styx,
+			"Ready",
+			metav1.ConditionFalse,
+			"NamespacesFetchFailed",
+			fmt.Sprintf("Failed to fetch namespaces: %v", err),
+		)
+		if updateErr := r.Status().Update(ctx, &styx); updateErr != nil {
+			logger.Error(updateErr, "Failed to update status after namespace fetch error")
+		}
+		return ctrl.Result{}, err
+	}
+
+	namespaceLabels := make(map[string]map[string]string, len(namespaces))
+	for _, ns := range namespaces {
+		namespaceLabels[ns.Name] = labelsForNamespace(&ns)
+	}
+
+	// The labels Styx writes are not evidence, or a wrong attribution would confirm itself
+	findOptions := crossplane.FindOptions{
+		ResourceTypes:    resourceTypeFilter(&styx.Spec.ResourceDiscovery),
+		DetectorSettings: detectorSettings(styx.Spec.Detectors),
+		Threshold:        fromPercent(styx.Spec.ConfidenceThreshold),
+		IgnoredLabels:    labelKeys(namespaceLabels),
+	}
+
+	labelOptions := crossplane.LabelOptions{
+		Mode:   crossplane.LabelWriteMode(styx.Spec.LabelWriteMode),
+		Fields: labelFieldRegistry(styx.Spec.LabelFields),
+	}
+
+	// Attribute each resource to the namespace it matches with the highest confidence,
+	// so a resource matching several namespaces is labeled consistently
+	ownership := newResourceOwnership()
+	excludedPods := make(map[string]int)
+	var labelErrors []string
+
+	// Node IPs are shared by hostNetwork pods and never identify a namespace
+	nodeIPs, err := fetchNodeIPs(ctx, r.Client)
+	if err != nil {
+		labelErrors = append(labelErrors, err.Error())
+		logger.Error(err, "Failed to fetch node addresses")
+	}
+
+	for _, ns := range namespaces {
+		pods, err := r.fetchPods(ctx, ns.Name)
+		if err != nil {
+			msg := fmt.Sprintf("Namespace %s: %v", ns.Name, err)
+			labelErrors = append(labelErrors, msg)
+			logger.Error(err, "Failed to fetch pods", "namespace", ns.Name)
+			continue
+		}
+
+		nsCtx, err := fetchNamespaceContext(ctx, r.Client, ns.Name, pods, nodeIPs)
+		if err != nil {
+			msg := fmt.Sprintf("Namespace %s: %v", ns.Name, err)
+			labelErrors = append(labelErrors, msg)
+			logger.Error(err, "Failed to fetch namespace workloads", "namespace", ns.Name)
+			continue
+		}
+
+		matches, err := r.CrossplaneClient.FindCrossplaneResourcesForNamespaceContext(ctx, nsCtx, findOptions)
+		if err != nil {
+			msg := fmt.Sprintf("Namespace %s: %v", ns.Name, err)
+			labelErrors = append(labelErrors, msg)
+			logger.Error(err, "Failed to find Crossplane resources", "namespace", ns.Name)
+			continue
+		}
+
+		for _, match := range matches {
+			ownership.add(match.Resource, ns.Name, match.ConfidenceScore, match.MatchReasons)
+		}
+		addExclusions(excludedPods, nsCtx)
+
+		if !styx.Spec.IncludeChildResources {
+			continue
+		}
+
+		// Claims and composites of the namespace pass it on to everything they compose
+		compositions, err := r.CrossplaneClient.FindCompositionsForNamespace(ctx, ns.Name)
+		if err != nil {
+			msg := fmt.Sprintf("Namespace %s: %v", ns.Name, err)
+			labelErrors = append(labelErrors, msg)
+			logger.Error(err, "Failed to find compositions", "namespace", ns.Name)
+			continue
+		}
+		for _, composition := range compositions {
+			root := composition.Composites[0]
+			reasons := []string{fmt.Sprintf("Composed by %s/%s", root.GetKind(), root.GetName())}
+			if composition.Claim != nil {
+				reasons = []string{fmt.Sprintf("Composed for claim %s/%s", composition.Claim.GetNamespace(), composition.Claim.GetName())}
+				ownership.add(*composition.Claim, ns.Name, crossplane.ClaimConfidence, reasons)
+			}
+			for _, composite := range composition.Composites {
+				ownership.add(composite, ns.Name, crossplane.ClaimConfidence, reasons)
+			}
+			for _, resource := range composition.Resources {
+				ownership.add(resource, ns.Name, crossplane.ClaimConfidence, reasons)
+			}
+		}
+	}
+
+	// Resources owned by an attributed resource inherit its namespace
+	if styx.Spec.IncludeChildResources && len(ownership.order) > 0 {
+		attributed := ownership.list()
+		parents := make([]unstructured.Unstructured, 0, len(attributed))
+		for _, owned := range attributed {
+			parents = append(parents, owned.resource)
+		}
+
+		descendants, err := r.CrossplaneClient.FindChildResources(ctx, parents)
+		if err != nil {
+			labelErrors = append(labelErrors, fmt.Sprintf("Child resources: %v", err))
+			logger.Error(err, "Failed to find child resources")
+		}
+
+		for _, parent := range attributed {
+			owner := parent.primary()
+			for _, child := range descendants[string(parent.resource.GetUID())] {
+				if _, ok := ownership.get(string(child.GetUID())); ok {
+					continue
+				}
+				ownership.add(child, owner.namespace, owner.confidence,
+					[]string{fmt.Sprintf("Child of %s/%s", parent.resource.GetKind(), parent.resource.GetName())})
+			}
+		}
+	}
+
+	// Apply labels to each resource
+	resourcesLabeled := 0
+	resourceCounts := make(map[string]int)
+	for _, owned := range ownership.list() {
+		owner := owned.primary()
+		resource := owned.resource
+
+		// Kinds that cannot carry GCP labels are skipped rather than reported as errors
+		if !labelOptions.Labellable(resource.GroupVersionKind()) {
+			logger.V(1).Info("Skipping resource kind without GCP labels",
+				"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()))
+			continue
+		}
+
+		if err := r.CrossplaneClient.ApplyLabelsToResource(
+			ctx,
+			resource,
+			namespaceLabels[owner.namespace],
+			labelOptions,
+		); err != nil {
+			// A resource missing the parent of its label field, such as a node pool without
+			// nodeConfig, cannot take GCP labels yet and is skipped rather than reported
+			if goerrors.Is(err, crossplane.ErrUnlabellable) {
+				logger.V(1).Info("Skipping resource without its GCP label field",
+					"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()),
+					"reason", err.Error())
+				continue
+			}
+			msg := fmt.Sprintf("Resource %s/%s: %v", resource.GetKind(), resource.GetName(), err)
+			labelErrors = append(labelErrors, msg)
+			logger.Error(err, "Failed to apply labels to resource",
+				"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()))
+			continue
+		}
+
+		logger.Info("Applied labels to resource",
+			"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()),
+			"namespace", owner.namespace,
+			"confidence", owner.confidence,
+			"matchReasons", owner.reasons)
+		resourceCounts[resourceCountKey(&resource)]++
+		resourcesLabeled++
+	}
+
+	// Update status
+	styx.Status.LastReconcileTime = metav1.Now()
+	styx.Status.ResourceCounts = resourceCounts
+	styx.Status.SharedResources, styx.Status.SharedResourceCount = ownership.shared()
+	styx.Status.ExcludedPods = excludedPods
+	r.updateCondition(
+		&styx,
+		"Ready",
+		metav1.ConditionTrue,
+		"ReconciliationSucceeded",
+		fmt.Sprintf("Successfully labeled %d resources", resourcesLabeled),
+	)
+	if len(labelErrors) > 0 {
+		// Limit the number of errors in the message to avoid very long messages
+		errorMsg := fmt.Sprintf("Errors: %v", labelErrors)
+		if len(labelErrors) > 3 {
+			errorMsg = fmt.Sprintf("%d errors occurred, first 3: %v", len(labelErrors), labelErrors[:3])
+		}
+		r.updateCondition(
+			&styx,
+			"LabelingErrors",
+			metav1.ConditionTrue,
+			"ResourceLabelingPartiallyFailed",
+			errorMsg,
+		)
+	} else {
+		r.updateCondition(
+			&styx,
+			"LabelingErrors",
+			metav1.ConditionFalse,
+			"NoErrors",
+			"All resources successfully labeled",
+		)
+	}
+	if err := r.Status().Update(ctx, &styx); err != nil {
+		logger.Error(err, "Failed to update Styx status")
+		return ctrl.Result{}, err
+	}
+
+	// Schedule next reconciliation based on the interval
+	interval := 300 // default: 5 minutes
+	if styx.Spec.IntervalSeconds > 0 {
+		interval = styx.Spec.IntervalSeconds
+	}
+
+	logger.Info("Reconciliation completed successfully",
+		"resourcesLabeled", resourcesLabeled,
+		"nextReconcileIn", interval,
+	)
+
+	return ctrl.Result{RequeueAfter: time.Duration(interval) * time.Second}, nil
+}
+
+// fetchNamespaces returns a list of namespaces matching the Styx selector
+func (r *StyxReconciler) fetchNamespaces(
+	ctx context.Context,
+	styx *crossplanev1alpha1.Styx,
+	logger logr.Logger,
+) ([]corev1.Namespace, error) {
+	namespaceList := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaceList); err != nil {
+		return nil, err
+	}
+
+	// If no selector is specified, return all namespaces
+	if styx.Spec.Selector == "" {
+		return namespaceList.Items, nil
+	}
+
+	// Compile the regex pattern
+	namespacePattern, err := regexp.Compile(styx.Spec.Selector)
+	if err != nil {
+		logger.Error(err, "Invalid selector pattern", "pattern", styx.Spec.Selector)
+		return nil, err
+	}
+
+	// Filter namespaces based on the pattern
+	var matchingNamespaces []corev1.Namespace
+	for _, ns := range namespaceList.Items {
+		if namespacePattern.MatchString(ns.Name) {
+			matchingNamespaces = append(matchingNamespaces, ns)
+		}
+	}
+
+	logger.Info("Matched namespaces", "count", len(matchingNamespaces))
+	return matchingNamespaces, nil
+}
+
+// fetchPods returns all pods in a namespace
+func (r *StyxReconciler) fetchPods(ctx context.Context, namespace string) ([]corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
+
+// labelsForNamespace returns the labels Styx applies to resources belonging to a namespace.
+// Prefixed label keys (such as kubernetes.io/metadata.name) are skipped since they are
+// Kubernetes-specific and not valid GCP label keys.
+func labelsForNamespace(ns *corev1.Namespace) map[string]string {
+	labels := map[string]string{namespaceLabelKey: ns.Name}
+	for key, value := range ns.Labels {
+		if strings.Contains(key, "/") {
+			continue
+		}
+		labels[key] = value
+	}
+	return labels
+}
+
+// labelKeys returns the keys of the labels written for any namespace
+func labelKeys(namespaceLabels map[string]map[string]string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, labels := range namespaceLabels {
+		for key := range labels {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// resourceCountKey returns the status.resourceCounts key for a resource, e.g.
+// compute.gcp.upbound.io/v1beta1.Instance
+func resourceCountKey(resource *unstructured.Unstructured) string {
+	gvk := resource.GroupVersionKind()
+	return fmt.Sprintf("%s.%s", gvk.GroupVersion().String(), gvk.Kind)
+}
+
+// updateCondition updates a condition in the Styx status
+func (r *StyxReconciler) updateCondition(
+	styx *crossplanev1alpha1.Styx,
+	conditionType string,
+	status metav1.ConditionStatus,
+	reason string,
+	message string,
+) {
+	condition := metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+
+	// Find existing condition
+	for i, c := range styx.Status.Conditions {
+		if c.Type == conditionType {
+			// Only update if something changed
+			if c.Status != status || c.Reason != reason || c.Message != message {
+				styx.Status.Conditions[i] = condition
+			}
+			return
+		}
+	}
+
+	// Add new condition
+	styx.Status.Conditions = append(styx.Status.Conditions, condition)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *StyxReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Create a Crossplane client unless one is shared with other reconcilers
+	if r.CrossplaneClient == nil {
+		crossplaneClient, err := NewCrossplaneHandler(mgr)
+		if err != nil {
+			return err
+		}
+		r.CrossplaneClient = crossplaneClient
+	}
+
+	// Status updates do not change the generation, so writing the status at the end of a
+	// reconcile does not start another one
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&crossplanev1alpha1.Styx{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 
 	"go.uber.org/zap/zapcore"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -16,6 +17,7 @@ import (
 
 	crossplanev1alpha1 "github.com/deen/styx/api/v1alpha1"
 	"github.com/deen/styx/controllers"
+	"github.com/deen/styx/pkg/crossplane"
 )
 
 var (
@@ -32,11 +34,20 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var flowLogFile string
+	var flowLogAddr string
+	var flowLogTokenFile string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&flowLogFile, "flow-log-file", "",
+		"A JSON-lines flow log file to ingest as network evidence. Disabled when empty.")
+	flag.StringVar(&flowLogAddr, "flow-log-bind-address", "",
+		"The address of an endpoint accepting JSON-lines flow records on POST /flows. Disabled when empty.")
+	flag.StringVar(&flowLogTokenFile, "flow-log-token-file", "",
+		"A file holding the bearer token flow pushes must carry. Required unless the endpoint is on a loopback address.")
 	opts := zap.Options{
 		Development: true,
 		TimeEncoder: zapcore.ISO8601TimeEncoder,
@@ -58,14 +69,59 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The reconcilers share one Crossplane handler and its managed resource cache
+	crossplaneClient, err := controllers.NewCrossplaneHandler(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create Crossplane client")
+		os.Exit(1)
+	}
+
+	// Flow logs add observed traffic to network-based detection
+	if flowLogFile != "" {
+		if err := mgr.Add(crossplane.NewFlowFileSource(crossplaneClient, flowLogFile)); err != nil {
+			setupLog.Error(err, "unable to add flow log file source")
+			os.Exit(1)
+		}
+	}
+	if flowLogAddr != "" {
+		var token string
+		if flowLogTokenFile != "" {
+			data, err := os.ReadFile(flowLogTokenFile)
+			if err != nil {
+				setupLog.Error(err, "unable to read flow log token")
+				os.Exit(1)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		source, err := crossplane.NewFlowHTTPSource(crossplaneClient, flowLogAddr, token)
+		if err != nil {
+			setupLog.Error(err, "unable to create flow log endpoint")
+			os.Exit(1)
+		}
+		if err := mgr.Add(source); err != nil {
+			setupLog.Error(err, "unable to add flow log endpoint")
+			os.Exit(1)
+		}
+	}
+
 	if err = (&controllers.CrossplaneLabellerReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		CrossplaneClient: crossplaneClient,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CrossplaneLabeller")
 		os.Exit(1)
 	}
 
+	if err = (&controllers.StyxReconciler{
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		CrossplaneClient: crossplaneClient,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Styx")
+		os.Exit(1)
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
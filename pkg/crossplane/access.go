@@ -0,0 +1,115 @@
+package crossplane
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+)
+
+// accessRecheckInterval is how long a resource type the controller may not watch is
+// left out before access is checked again, so RBAC granted later is picked up
+const accessRecheckInterval = 5 * time.Minute
+
+// accessChecker limits discovery to the resource types RBAC lets the controller list and
+// watch. Without it, informers for the other types fail with forbidden errors, never sync,
+// and every lookup on them falls back to listing from the API server.
+type accessChecker struct {
+	reviews authorizationv1client.SelfSubjectAccessReviewInterface
+
+	mu      sync.Mutex
+	allowed map[schema.GroupVersionResource]bool
+	denied  map[schema.GroupVersionResource]time.Time
+}
+
+// newAccessChecker creates an access checker using SelfSubjectAccessReviews
+func newAccessChecker(reviews authorizationv1client.SelfSubjectAccessReviewInterface) *accessChecker {
+	return &accessChecker{
+		reviews: reviews,
+		allowed: make(map[schema.GroupVersionResource]bool),
+		denied:  make(map[schema.GroupVersionResource]time.Time),
+	}
+}
+
+// filter returns the resource types the controller may list and watch. A nil checker
+// allows every type.
+func (a *accessChecker) filter(ctx context.Context, types []ManagedResourceType) []ManagedResourceType {
+	if a == nil {
+		return types
+	}
+	permitted := make([]ManagedResourceType, 0, len(types))
+	for _, t := range types {
+		if a.canWatch(ctx, t.GVR) {
+			permitted = append(permitted, t)
+		}
+	}
+	return permitted
+}
+
+// canWatch reports whether the controller may list and watch a resource type. Granted
+// access is remembered; denied access is checked again after accessRecheckInterval.
+// When the review itself fails the type is allowed, as before access was checked.
+func (a *accessChecker) canWatch(ctx context.Context, gvr schema.GroupVersionResource) bool {
+	a.mu.Lock()
+	if a.allowed[gvr] {
+		a.mu.Unlock()
+		return true
+	}
+	deniedAt, wasDenied := a.denied[gvr]
+	a.mu.Unlock()
+	if wasDenied && time.Since(deniedAt) < accessRecheckInterval {
+		return false
+	}
+
+	allowed := true
+	for _, verb := range []string{"list", "watch"} {
+		ok, err := a.review(ctx, gvr, verb)
+		if err != nil {
+			log.Error(err, "Failed to review access to resource type", "gvr", gvr.String(), "verb", verb)
+			return true
+		}
+		if !ok {
+			allowed = false
+			break
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if allowed {
+		a.allowed[gvr] = true
+		delete(a.denied, gvr)
+		if wasDenied {
+			log.Info("Access granted to resource type", "gvr", gvr.String())
+		}
+		return true
+	}
+	a.denied[gvr] = time.Now()
+	if !wasDenied {
+		log.Info("Skipping resource type the controller may not list and watch; grant access to its API group in the ClusterRole",
+			"gvr", gvr.String())
+	}
+	return false
+}
+
+// review asks the API server whether the controller may perform a verb on a resource type
+func (a *accessChecker) review(ctx context.Context, gvr schema.GroupVersionResource, verb string) (bool, error) {
+	review, err := a.reviews.Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Group:    gvr.Group,
+				Version:  gvr.Version,
+				Resource: gvr.Resource,
+				Verb:     verb,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
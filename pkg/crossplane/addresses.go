@@ -0,0 +1,121 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// IngressStaticIPAnnotation names the reserved global address of a GKE Ingress
+	IngressStaticIPAnnotation = "kubernetes.io/ingress.global-static-ip-name"
+
+	// staticAddressConfidence is the confidence of a match backed by a Service or Ingress
+	// using a reserved address
+	staticAddressConfidence = 0.95
+)
+
+// addressKinds are the compute kinds of reserved addresses
+var addressKinds = map[string]bool{
+	"Address":       true,
+	"GlobalAddress": true,
+}
+
+// reservedAddressIP returns the IP of a reserved address managed resource, as requested
+// in its spec or as assigned in its status
+func reservedAddressIP(resource *unstructured.Unstructured) string {
+	if ip, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "address"); ip != "" {
+		return ip
+	}
+	ip, _, _ := unstructured.NestedString(resource.Object, "status", "atProvider", "address")
+	return ip
+}
+
+// serviceLoadBalancerIPs returns the IPs a LoadBalancer Service is exposed on
+func serviceLoadBalancerIPs(service *corev1.Service) []string {
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return nil
+	}
+
+	var ips []string
+	if service.Spec.LoadBalancerIP != "" {
+		ips = append(ips, service.Spec.LoadBalancerIP)
+	}
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			ips = append(ips, ingress.IP)
+		}
+	}
+	return canonicalIPs(ips)
+}
+
+// findStaticAddresses returns evidence for the reserved addresses used by the LoadBalancer
+// Services of a namespace, matched by IP, and by its Ingresses, matched against the
+// external name of global addresses in their static IP annotation
+func (h *CrossplaneHandler) findStaticAddresses(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	nsCtx *NamespaceContext,
+) []Evidence {
+	usersByIP := make(map[string]string)
+	for i := range nsCtx.Services {
+		service := &nsCtx.Services[i]
+		for _, ip := range serviceLoadBalancerIPs(service) {
+			if _, ok := usersByIP[ip]; !ok {
+				usersByIP[ip] = fmt.Sprintf("LoadBalancer Service %s/%s", nsCtx.Namespace, service.Name)
+			}
+		}
+	}
+
+	usersByName := make(map[string]string)
+	for _, ingress := range nsCtx.Ingresses {
+		if name := ingress.Annotations[IngressStaticIPAnnotation]; name != "" {
+			if _, ok := usersByName[name]; !ok {
+				usersByName[name] = fmt.Sprintf("Ingress %s/%s", nsCtx.Namespace, ingress.Name)
+			}
+		}
+	}
+	if len(usersByIP) == 0 && len(usersByName) == 0 {
+		return nil
+	}
+
+	var evidence []Evidence
+	for _, t := range resourceTypes {
+		if t.GVR.Group != "compute.gcp.upbound.io" || !addressKinds[t.Kind] {
+			continue
+		}
+		for _, resource := range h.listNamespaced(ctx, t.GVR, "") {
+			resource := resource
+			if addr, ok := parseIP(reservedAddressIP(&resource)); ok {
+				ip := addr.String()
+				if user, ok := usersByIP[ip]; ok {
+					evidence = append(evidence, Evidence{
+						Resource:   resource,
+						Confidence: staticAddressConfidence,
+						Reason:     fmt.Sprintf("%s is exposed on reserved address %s", user, ip),
+					})
+					continue
+				}
+			}
+
+			// The annotation names a global address; regional addresses of the same name
+			// belong to something else
+			if t.Kind != "GlobalAddress" {
+				continue
+			}
+			for name, user := range usersByName {
+				if matchesExternalName(&resource, name) {
+					evidence = append(evidence, Evidence{
+						Resource:   resource,
+						Confidence: staticAddressConfidence,
+						Reason:     fmt.Sprintf("%s uses static IP %s through %s", user, name, IngressStaticIPAnnotation),
+					})
+					break
+				}
+			}
+		}
+	}
+	return evidence
+}
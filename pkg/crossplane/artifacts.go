@@ -0,0 +1,119 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// artifactRegistryGroup is the API group of the Artifact Registry managed resources
+	artifactRegistryGroup = "artifact.gcp.upbound.io"
+
+	// artifactRegistryHostSuffix is the host suffix of Artifact Registry Docker repositories
+	artifactRegistryHostSuffix = "-docker.pkg.dev"
+
+	// imageRepositoryConfidence is the confidence of a match backed by a pod pulling an
+	// image from a repository. Repositories are often shared, so it stays below the
+	// confidence of exclusive links such as connection secrets.
+	imageRepositoryConfidence = 0.8
+)
+
+// imageRepository is the Artifact Registry repository an image is pulled from
+type imageRepository struct {
+	Location   string
+	Project    string
+	Repository string
+}
+
+// String returns the repository in image reference form
+func (r imageRepository) String() string {
+	return fmt.Sprintf("%s%s/%s/%s", r.Location, artifactRegistryHostSuffix, r.Project, r.Repository)
+}
+
+// parseImageRepository parses an image reference such as
+// europe-west1-docker.pkg.dev/my-project/my-repo/app:1.0 into its Artifact Registry repository
+func parseImageRepository(image string) (imageRepository, bool) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+
+	parts := strings.Split(image, "/")
+	if len(parts) < 4 || !strings.HasSuffix(parts[0], artifactRegistryHostSuffix) {
+		return imageRepository{}, false
+	}
+	location := strings.TrimSuffix(parts[0], artifactRegistryHostSuffix)
+	if location == "" || parts[1] == "" || parts[2] == "" {
+		return imageRepository{}, false
+	}
+
+	return imageRepository{Location: location, Project: parts[1], Repository: parts[2]}, true
+}
+
+// matchesRepository reports whether a Repository managed resource is the repository an image
+// is pulled from. Its location and project are compared when they are known.
+func (h *CrossplaneHandler) matchesRepository(resource *unstructured.Unstructured, repo imageRepository) bool {
+	if !matchesExternalName(resource, repo.Repository) {
+		return false
+	}
+	if location, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "location"); location != "" && location != repo.Location {
+		return false
+	}
+	project, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "project")
+	if project == "" {
+		project = h.projectID
+	}
+	return project == "" || project == repo.Project
+}
+
+// findImageRepositories returns evidence for the Artifact Registry repositories the pods of a
+// namespace pull their container and init container images from
+func (h *CrossplaneHandler) findImageRepositories(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	nsCtx *NamespaceContext,
+) []Evidence {
+	// Keep the first image pulled from each repository
+	var repos []imageRepository
+	pulls := make(map[imageRepository]string)
+	for _, pod := range nsCtx.Pods {
+		containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+		for _, container := range containers {
+			repo, ok := parseImageRepository(container.Image)
+			if !ok {
+				continue
+			}
+			if _, ok := pulls[repo]; !ok {
+				repos = append(repos, repo)
+				pulls[repo] = fmt.Sprintf("Pod %s pulls %s", pod.Name, container.Image)
+			}
+		}
+	}
+	if len(repos) == 0 {
+		return nil
+	}
+
+	var evidence []Evidence
+	for _, t := range resourceTypes {
+		if t.GVR.Group != artifactRegistryGroup || t.Kind != "Repository" {
+			continue
+		}
+		for _, resource := range h.listNamespaced(ctx, t.GVR, "") {
+			resource := resource
+			for _, repo := range repos {
+				if h.matchesRepository(&resource, repo) {
+					evidence = append(evidence, Evidence{
+						Resource:   resource,
+						Confidence: imageRepositoryConfidence,
+						Reason:     fmt.Sprintf("%s from Artifact Registry repository %s", pulls[repo], repo),
+					})
+					break
+				}
+			}
+		}
+	}
+	return evidence
+}
@@ -0,0 +1,329 @@
+package crossplane
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"unicode"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// nameTokenIndex indexes resources by the lowercase tokens of their name
+	nameTokenIndex = "nameToken"
+	// labelIndex indexes resources by key=value for their metadata and forProvider labels
+	labelIndex = "label"
+	// ipIndex indexes resources by the IP addresses found in their spec and status
+	ipIndex = "ip"
+	// connectionSecretIndex indexes resources by the namespace/name of their connection secret
+	connectionSecretIndex = "connectionSecret"
+	// hostnameIndex indexes resources by the hostnames of their endpoints
+	hostnameIndex = "hostname"
+)
+
+// resourceInformer is an informer for one managed resource type
+type resourceInformer struct {
+	informer cache.SharedIndexInformer
+	cancel   context.CancelFunc
+}
+
+// resourceCache serves managed resources from shared dynamic informers, one per
+// managed resource type, so lookups do not hit the API server
+type resourceCache struct {
+	dynamicClient dynamic.Interface
+	indexers      cache.Indexers
+
+	mu        sync.RWMutex
+	ctx       context.Context
+	informers map[schema.GroupVersionResource]*resourceInformer
+	handlers  []cache.ResourceEventHandler
+}
+
+// newResourceCache creates a resource cache; informers are only started by sync
+func newResourceCache(
+	dynamicClient dynamic.Interface,
+	extractIPs func(*unstructured.Unstructured) []string,
+	serviceAccountKeys func(*unstructured.Unstructured) []string,
+) *resourceCache {
+	return &resourceCache{
+		dynamicClient: dynamicClient,
+		indexers: cache.Indexers{
+			nameTokenIndex: func(obj interface{}) ([]string, error) {
+				u, ok := obj.(*unstructured.Unstructured)
+				if !ok {
+					return nil, nil
+				}
+				return nameTokens(u.GetName()), nil
+			},
+			labelIndex: func(obj interface{}) ([]string, error) {
+				u, ok := obj.(*unstructured.Unstructured)
+				if !ok {
+					return nil, nil
+				}
+				return labelIndexKeys(u), nil
+			},
+			ipIndex: func(obj interface{}) ([]string, error) {
+				u, ok := obj.(*unstructured.Unstructured)
+				if !ok {
+					return nil, nil
+				}
+				return dedupe(extractIPs(u)), nil
+			},
+			connectionSecretIndex: func(obj interface{}) ([]string, error) {
+				u, ok := obj.(*unstructured.Unstructured)
+				if !ok {
+					return nil, nil
+				}
+				if key, ok := connectionSecretKey(u); ok {
+					return []string{key}, nil
+				}
+				return nil, nil
+			},
+			serviceAccountIndex: func(obj interface{}) ([]string, error) {
+				u, ok := obj.(*unstructured.Unstructured)
+				if !ok {
+					return nil, nil
+				}
+				return serviceAccountKeys(u), nil
+			},
+			hostnameIndex: func(obj interface{}) ([]string, error) {
+				u, ok := obj.(*unstructured.Unstructured)
+				if !ok {
+					return nil, nil
+				}
+				return extractHostnames(u), nil
+			},
+			identifierIndex: func(obj interface{}) ([]string, error) {
+				u, ok := obj.(*unstructured.Unstructured)
+				if !ok {
+					return nil, nil
+				}
+				return resourceIdentifiers(u), nil
+			},
+		},
+		informers: make(map[schema.GroupVersionResource]*resourceInformer),
+	}
+}
+
+// start enables the cache; informers run until the context is cancelled
+func (c *resourceCache) start(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ctx = ctx
+}
+
+// sync starts informers for new resource types and stops the informers of types
+// that are no longer present
+func (c *resourceCache) sync(types []ManagedResourceType) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx == nil {
+		return
+	}
+
+	wanted := make(map[schema.GroupVersionResource]bool, len(types))
+	for _, t := range types {
+		wanted[t.GVR] = true
+		if _, ok := c.informers[t.GVR]; ok {
+			continue
+		}
+
+		informer := dynamicinformer.NewFilteredDynamicInformer(
+			c.dynamicClient, t.GVR, metav1.NamespaceAll, 0, c.indexers, nil,
+		).Informer()
+		for _, handler := range c.handlers {
+			if _, err := informer.AddEventHandler(handler); err != nil {
+				log.Error(err, "Failed to add event handler", "gvr", t.GVR.String())
+			}
+		}
+		informerCtx, cancel := context.WithCancel(c.ctx)
+		c.informers[t.GVR] = &resourceInformer{informer: informer, cancel: cancel}
+		go informer.Run(informerCtx.Done())
+		log.V(1).Info("Started informer for managed resource type", "gvr", t.GVR.String())
+	}
+
+	for gvr, ri := range c.informers {
+		if !wanted[gvr] {
+			ri.cancel()
+			delete(c.informers, gvr)
+			log.V(1).Info("Stopped informer for managed resource type", "gvr", gvr.String())
+		}
+	}
+}
+
+// addEventHandler registers an event handler with the informers of all current and
+// future resource types
+func (c *resourceCache) addEventHandler(handler cache.ResourceEventHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers = append(c.handlers, handler)
+	for gvr, ri := range c.informers {
+		if _, err := ri.informer.AddEventHandler(handler); err != nil {
+			log.Error(err, "Failed to add event handler", "gvr", gvr.String())
+		}
+	}
+}
+
+// informerFor returns the synced informer of a resource type
+func (c *resourceCache) informerFor(gvr schema.GroupVersionResource) (cache.SharedIndexInformer, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ri, ok := c.informers[gvr]
+	if !ok || !ri.informer.HasSynced() {
+		return nil, false
+	}
+	return ri.informer, true
+}
+
+// list returns the cached resources of a type, and false when the type is not cached yet
+func (c *resourceCache) list(gvr schema.GroupVersionResource) ([]unstructured.Unstructured, bool) {
+	informer, ok := c.informerFor(gvr)
+	if !ok {
+		return nil, false
+	}
+	return toUnstructured(informer.GetStore().List()), true
+}
+
+// get returns a cached resource by namespace and name, and false when it is not cached.
+// Leave the namespace empty for cluster-scoped resources.
+func (c *resourceCache) get(gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, bool) {
+	informer, ok := c.informerFor(gvr)
+	if !ok {
+		return nil, false
+	}
+	key := name
+	if namespace != "" {
+		key = namespace + "/" + name
+	}
+	obj, exists, err := informer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return nil, false
+	}
+	resource, ok := obj.(*unstructured.Unstructured)
+	return resource, ok
+}
+
+// partition splits resource types into those served by a synced informer and those that
+// are not cached yet, so lookups can use the indexes of the former and only scan the latter
+func (c *resourceCache) partition(types []ManagedResourceType) ([]ManagedResourceType, []ManagedResourceType) {
+	var cached, uncached []ManagedResourceType
+	for _, t := range types {
+		if _, ok := c.informerFor(t.GVR); ok {
+			cached = append(cached, t)
+		} else {
+			uncached = append(uncached, t)
+		}
+	}
+	return cached, uncached
+}
+
+// byIndex returns the cached resources of the given types with an index value. Types that
+// are not cached are skipped; use partition to find them.
+func (c *resourceCache) byIndex(types []ManagedResourceType, indexName, value string) []unstructured.Unstructured {
+	var resources []unstructured.Unstructured
+	for _, t := range types {
+		informer, ok := c.informerFor(t.GVR)
+		if !ok {
+			continue
+		}
+		objs, err := informer.GetIndexer().ByIndex(indexName, value)
+		if err != nil {
+			log.Error(err, "Failed to look up index", "index", indexName, "gvr", t.GVR.String())
+			continue
+		}
+		resources = append(resources, toUnstructured(objs)...)
+	}
+	return resources
+}
+
+// indexValues returns the values of an index across the cached resources of the given
+// types. Types that are not cached are skipped.
+func (c *resourceCache) indexValues(types []ManagedResourceType, indexName string) []string {
+	var values []string
+	for _, t := range types {
+		informer, ok := c.informerFor(t.GVR)
+		if !ok {
+			continue
+		}
+		values = append(values, informer.GetIndexer().ListIndexFuncValues(indexName)...)
+	}
+	return dedupe(values)
+}
+
+// toUnstructured converts informer store objects. The objects are shared with the
+// cache and must not be modified.
+func toUnstructured(objs []interface{}) []unstructured.Unstructured {
+	resources := make([]unstructured.Unstructured, 0, len(objs))
+	for _, obj := range objs {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			resources = append(resources, *u)
+		}
+	}
+	return resources
+}
+
+// nameTokens splits a resource name into lowercase alphanumeric tokens
+func nameTokens(name string) []string {
+	return dedupe(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}))
+}
+
+// labelIndexKeys returns the key=value pairs of the metadata and forProvider labels of a resource
+func labelIndexKeys(resource *unstructured.Unstructured) []string {
+	var keys []string
+	for k, v := range resource.GetLabels() {
+		keys = append(keys, k+"="+v)
+	}
+	if labels, ok, _ := unstructured.NestedStringMap(resource.Object, "spec", "forProvider", "labels"); ok {
+		for k, v := range labels {
+			keys = append(keys, k+"="+v)
+		}
+	}
+	return dedupe(keys)
+}
+
+// dedupe removes duplicate strings, keeping the first occurrence
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := values[:0]
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// listResources returns the resources of the selected types, served from the cache
+// where possible and listed from the API server otherwise
+func (h *CrossplaneHandler) listResources(ctx context.Context, filter ResourceTypeFilter) []unstructured.Unstructured {
+	return h.listResourcesOfTypes(ctx, h.ManagedResourceTypes(ctx, filter))
+}
+
+// listResourcesOfTypes returns the resources of the given types, served from the cache
+// where possible and listed from the API server otherwise
+func (h *CrossplaneHandler) listResourcesOfTypes(ctx context.Context, resourceTypes []ManagedResourceType) []unstructured.Unstructured {
+	var resources []unstructured.Unstructured
+	for _, resourceType := range resourceTypes {
+		if cached, ok := h.cache.list(resourceType.GVR); ok {
+			resources = append(resources, cached...)
+			continue
+		}
+
+		list, err := h.dynamicClient.Resource(resourceType.GVR).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			log.Error(err, "Failed to list resources", "gvr", resourceType.GVR.String())
+			continue
+		}
+		resources = append(resources, list.Items...)
+	}
+	return resources
+}
@@ -0,0 +1,136 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// ClaimNamespaceLabel is set by Crossplane on resources composed for a claim
+	ClaimNamespaceLabel = "crossplane.io/claim-namespace"
+	// ClaimNameLabel is set by Crossplane on resources composed for a claim
+	ClaimNameLabel = "crossplane.io/claim-name"
+
+	// ClaimConfidence is the confidence of a match backed by a claim reference
+	ClaimConfidence = 1.0
+
+	// maxCompositeDepth limits how many composites are walked up from a resource
+	maxCompositeDepth = 8
+)
+
+// ClaimReference identifies the claim a resource was composed for
+type ClaimReference struct {
+	Namespace string
+	Name      string
+	// Source describes where the reference was found
+	Source string
+}
+
+// claimFromLabels returns the claim recorded in the Crossplane claim labels of a resource
+func claimFromLabels(resource *unstructured.Unstructured) (ClaimReference, bool) {
+	labels := resource.GetLabels()
+	namespace := labels[ClaimNamespaceLabel]
+	if namespace == "" {
+		return ClaimReference{}, false
+	}
+	return ClaimReference{
+		Namespace: namespace,
+		Name:      labels[ClaimNameLabel],
+		Source:    fmt.Sprintf("%s label on %s/%s", ClaimNamespaceLabel, resource.GetKind(), resource.GetName()),
+	}, true
+}
+
+// claimFromClaimRef returns the claim referenced by spec.claimRef of a composite resource
+func claimFromClaimRef(composite *unstructured.Unstructured) (ClaimReference, bool) {
+	namespace, _, _ := unstructured.NestedString(composite.Object, "spec", "claimRef", "namespace")
+	if namespace == "" {
+		return ClaimReference{}, false
+	}
+	name, _, _ := unstructured.NestedString(composite.Object, "spec", "claimRef", "name")
+	return ClaimReference{
+		Namespace: namespace,
+		Name:      name,
+		Source:    fmt.Sprintf("spec.claimRef of %s/%s", composite.GetKind(), composite.GetName()),
+	}, true
+}
+
+// ClaimFor returns the claim a resource was composed for. The Crossplane claim labels
+// are used when present; otherwise the composite resources owning the resource are
+// walked up and the first spec.claimRef is used. Resources that were not created
+// through a claim have no claim reference.
+func (h *CrossplaneHandler) ClaimFor(ctx context.Context, resource *unstructured.Unstructured) (ClaimReference, bool) {
+	current := resource
+	for depth := 0; depth <= maxCompositeDepth; depth++ {
+		if claim, ok := claimFromLabels(current); ok {
+			return claim, true
+		}
+		if claim, ok := claimFromClaimRef(current); ok {
+			return claim, true
+		}
+
+		composite, ok := h.compositeOf(ctx, current)
+		if !ok {
+			return ClaimReference{}, false
+		}
+		current = composite
+	}
+	return ClaimReference{}, false
+}
+
+// compositeOf returns the composite resource owning a resource. Only owners of a
+// discovered composite resource type are followed.
+func (h *CrossplaneHandler) compositeOf(ctx context.Context, resource *unstructured.Unstructured) (*unstructured.Unstructured, bool) {
+	if h.mockMode {
+		return nil, false
+	}
+
+	composites, _ := h.composites.list()
+	for _, owner := range resource.GetOwnerReferences() {
+		gv, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err != nil {
+			continue
+		}
+
+		for _, t := range composites {
+			if t.GVR.Group != gv.Group || t.Kind != owner.Kind {
+				continue
+			}
+
+			if composite, ok := h.cache.get(t.GVR, "", owner.Name); ok {
+				return composite, true
+			}
+			composite, err := h.dynamicClient.Resource(t.GVR).Get(ctx, owner.Name, metav1.GetOptions{})
+			if err != nil {
+				log.V(1).Info("Failed to get composite resource",
+					"composite", fmt.Sprintf("%s/%s", owner.Kind, owner.Name),
+					"error", err.Error())
+				return nil, false
+			}
+			return composite, true
+		}
+	}
+	return nil, false
+}
+
+// claimMatch evaluates the claim of a resource against a namespace. A resource composed
+// for a claim belongs to the claim's namespace only: it matches that namespace with full
+// confidence and no other namespace at all. The last result is false for resources
+// without a claim, which are left to the other detection methods.
+func (h *CrossplaneHandler) claimMatch(ctx context.Context, resource *unstructured.Unstructured, namespace string) (ResourceMatch, bool, bool) {
+	claim, ok := h.ClaimFor(ctx, resource)
+	if !ok {
+		return ResourceMatch{}, false, false
+	}
+	if claim.Namespace != namespace {
+		return ResourceMatch{}, false, true
+	}
+	return ResourceMatch{
+		Resource:        *resource,
+		ConfidenceScore: ClaimConfidence,
+		MatchReasons:    []string{fmt.Sprintf("Resource was composed for claim %s/%s (%s)", claim.Namespace, claim.Name, claim.Source)},
+	}, true, true
+}
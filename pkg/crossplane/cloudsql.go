@@ -0,0 +1,258 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// cloudSQLGroup is the API group of the Cloud SQL managed resources
+	cloudSQLGroup = "sql.gcp.upbound.io"
+
+	// Confidences of a Cloud SQL instance a pod connects to and of its databases and users
+	cloudSQLInstanceConfidence = 0.95
+	cloudSQLChildConfidence    = 0.9
+)
+
+// cloudSQLProxyNames are image and binary names of the Cloud SQL Auth Proxy, v2 and v1
+var cloudSQLProxyNames = []string{"cloud-sql-proxy", "cloud_sql_proxy", "cloudsql-proxy", "gce-proxy"}
+
+// cloudSQLConnection is a Cloud SQL instance connection name, project:region:instance
+type cloudSQLConnection struct {
+	Project  string
+	Region   string
+	Instance string
+}
+
+// String returns the connection name
+func (c cloudSQLConnection) String() string {
+	return fmt.Sprintf("%s:%s:%s", c.Project, c.Region, c.Instance)
+}
+
+// parseConnectionName parses an instance connection name. Projects scoped to a domain
+// (example.com:project) contain a colon themselves.
+func parseConnectionName(value string) (cloudSQLConnection, bool) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 3 {
+		return cloudSQLConnection{}, false
+	}
+	n := len(parts)
+	connection := cloudSQLConnection{
+		Project:  strings.Join(parts[:n-2], ":"),
+		Region:   parts[n-2],
+		Instance: parts[n-1],
+	}
+	if connection.Project == "" || connection.Region == "" || connection.Instance == "" ||
+		strings.ContainsAny(value, "/ ") {
+		return cloudSQLConnection{}, false
+	}
+	return connection, true
+}
+
+// isCloudSQLProxy reports whether a container runs the Cloud SQL Auth Proxy
+func isCloudSQLProxy(container *corev1.Container) bool {
+	image := container.Image
+	if i := strings.LastIndex(image, "/"); i >= 0 {
+		image = image[i+1:]
+	}
+	image, _, _ = strings.Cut(image, "@")
+	image, _, _ = strings.Cut(image, ":")
+
+	var binary string
+	if len(container.Command) > 0 {
+		binary = path.Base(container.Command[0])
+	}
+	for _, name := range cloudSQLProxyNames {
+		if image == name || binary == name {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConnectionNames returns the instance connection names a proxy container serves: the
+// comma separated -instances flag of v1, whose entries may carry =tcp:PORT, and the
+// positional arguments of v2, which may carry ?port=PORT
+func proxyConnectionNames(container *corev1.Container) []string {
+	args := append([]string{}, container.Command...)
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	args = append(args, container.Args...)
+
+	var names []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			name, _, _ := strings.Cut(arg, "?")
+			names = append(names, name)
+			continue
+		}
+
+		flag, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if flag != "instances" {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		for _, entry := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(entry, "=")
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// isConnectionNameEnv reports whether an env var holds an instance connection name for a
+// Cloud SQL connector or for the proxy's environment configuration
+func isConnectionNameEnv(name string) bool {
+	return strings.HasSuffix(name, "INSTANCE_CONNECTION_NAME") ||
+		name == "CLOUD_SQL_CONNECTION_NAME" ||
+		strings.HasPrefix(name, "CSQL_PROXY_INSTANCE_CONNECTION_NAME")
+}
+
+// podCloudSQLConnections returns the instance connection names a pod connects to, mapped to
+// how they were found: proxy sidecar args and connector env vars, literal or from a ConfigMap
+func podCloudSQLConnections(pod *corev1.Pod, configMaps map[string]*corev1.ConfigMap) map[cloudSQLConnection]string {
+	connections := make(map[cloudSQLConnection]string)
+	add := func(value, how string) {
+		connection, ok := parseConnectionName(strings.TrimSpace(value))
+		if _, seen := connections[connection]; ok && !seen {
+			connections[connection] = how
+		}
+	}
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for i := range containers {
+		container := &containers[i]
+		if isCloudSQLProxy(container) {
+			for _, name := range proxyConnectionNames(container) {
+				add(name, fmt.Sprintf("Cloud SQL proxy container %s", container.Name))
+			}
+		}
+
+		for _, env := range container.Env {
+			if !isConnectionNameEnv(env.Name) {
+				continue
+			}
+			how := fmt.Sprintf("env %s in container %s", env.Name, container.Name)
+			if env.Value != "" {
+				add(env.Value, how)
+				continue
+			}
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil {
+				ref := env.ValueFrom.ConfigMapKeyRef
+				if configMap, ok := configMaps[ref.Name]; ok {
+					add(configMap.Data[ref.Key], fmt.Sprintf("%s from ConfigMap %s", how, ref.Name))
+				}
+			}
+		}
+	}
+	return connections
+}
+
+// matchesCloudSQLInstance reports whether a DatabaseInstance managed resource is the instance
+// of a connection name, by its observed connection name or by its external name, project
+// and region
+func (h *CrossplaneHandler) matchesCloudSQLInstance(resource *unstructured.Unstructured, connection cloudSQLConnection) bool {
+	if name, _, _ := unstructured.NestedString(resource.Object, "status", "atProvider", "connectionName"); name != "" {
+		return name == connection.String()
+	}
+	if !matchesExternalName(resource, connection.Instance) {
+		return false
+	}
+	if region, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "region"); region != "" && region != connection.Region {
+		return false
+	}
+	project, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "project")
+	if project == "" {
+		project = h.projectID
+	}
+	return project == "" || project == connection.Project
+}
+
+// isCloudSQLChild reports whether a Database or User managed resource belongs to an
+// instance, through spec.forProvider.instanceRef or the instance's external name
+func isCloudSQLChild(resource, instance *unstructured.Unstructured) bool {
+	if ref, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "instanceRef", "name"); ref != "" {
+		return ref == instance.GetName()
+	}
+	name, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "instance")
+	return name != "" && matchesExternalName(instance, name)
+}
+
+// findCloudSQLConnections returns evidence for the Cloud SQL instances the pods of a namespace
+// connect to through the Cloud SQL Auth Proxy or a Cloud SQL connector, and for the
+// databases and users of those instances
+func (h *CrossplaneHandler) findCloudSQLConnections(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	nsCtx *NamespaceContext,
+) []Evidence {
+	configMaps := make(map[string]*corev1.ConfigMap, len(nsCtx.ConfigMaps))
+	for i := range nsCtx.ConfigMaps {
+		configMaps[nsCtx.ConfigMaps[i].Name] = &nsCtx.ConfigMaps[i]
+	}
+
+	// Keep the first pod connecting to each instance
+	var connections []cloudSQLConnection
+	users := make(map[cloudSQLConnection]string)
+	for i := range nsCtx.Pods {
+		pod := &nsCtx.Pods[i]
+		for connection, how := range podCloudSQLConnections(pod, configMaps) {
+			if _, ok := users[connection]; !ok {
+				connections = append(connections, connection)
+				users[connection] = fmt.Sprintf("Pod %s connects to Cloud SQL instance %s through %s", pod.Name, connection, how)
+			}
+		}
+	}
+	if len(connections) == 0 {
+		return nil
+	}
+
+	var instances, children []unstructured.Unstructured
+	for _, t := range resourceTypes {
+		if t.GVR.Group != cloudSQLGroup {
+			continue
+		}
+		switch t.Kind {
+		case "DatabaseInstance":
+			instances = append(instances, h.listNamespaced(ctx, t.GVR, "")...)
+		case "Database", "User":
+			children = append(children, h.listNamespaced(ctx, t.GVR, "")...)
+		}
+	}
+
+	var evidence []Evidence
+	for i := range instances {
+		instance := &instances[i]
+		for _, connection := range connections {
+			if !h.matchesCloudSQLInstance(instance, connection) {
+				continue
+			}
+			evidence = append(evidence, Evidence{
+				Resource:   *instance,
+				Confidence: cloudSQLInstanceConfidence,
+				Reason:     users[connection],
+			})
+			for j := range children {
+				if isCloudSQLChild(&children[j], instance) {
+					evidence = append(evidence, Evidence{
+						Resource:   children[j],
+						Confidence: cloudSQLChildConfidence,
+						Reason:     fmt.Sprintf("%s, which hosts %s %s", users[connection], children[j].GetKind(), children[j].GetName()),
+					})
+				}
+			}
+			break
+		}
+	}
+	return evidence
+}
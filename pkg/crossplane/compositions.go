@@ -0,0 +1,183 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Composition is a composite resource tree rooted at a claim or composite resource
+type Composition struct {
+	// Claim is the claim of the root composite, if it was created through one
+	Claim *unstructured.Unstructured
+	// Composites are the root composite and the composites nested in it
+	Composites []unstructured.Unstructured
+	// Resources are the managed resources composed anywhere in the tree
+	Resources []unstructured.Unstructured
+}
+
+// FindCompositionsForNamespace returns the compositions associated with a namespace: those
+// of the claims in the namespace and of the composites whose spec.claimRef points into it.
+// Each composition is walked down spec.resourceRefs, including nested composites.
+func (h *CrossplaneHandler) FindCompositionsForNamespace(ctx context.Context, namespace string) ([]Composition, error) {
+	if h.mockMode {
+		log.Info("Mock mode: Finding compositions for namespace", "namespace", namespace)
+		return []Composition{}, nil
+	}
+
+	var compositions []Composition
+	seen := make(map[string]bool)
+
+	// Claims in the namespace lead to their composite through spec.resourceRef
+	claimTypes, _ := h.claims.list()
+	for _, claimType := range claimTypes {
+		for _, claim := range h.listNamespaced(ctx, claimType.GVR, namespace) {
+			claim := claim
+			composite, ok := h.resolveRef(ctx, &claim, "spec", "resourceRef")
+			if !ok || seen[string(composite.GetUID())] {
+				continue
+			}
+			seen[string(composite.GetUID())] = true
+
+			composition := Composition{Claim: &claim}
+			h.walkComposite(ctx, composite, &composition, seen, 0)
+			compositions = append(compositions, composition)
+		}
+	}
+
+	// Composites can reference a claim whose type was not discovered
+	compositeTypes, _ := h.composites.list()
+	for _, compositeType := range compositeTypes {
+		for _, composite := range h.listNamespaced(ctx, compositeType.GVR, "") {
+			composite := composite
+			if seen[string(composite.GetUID())] {
+				continue
+			}
+			if claim, ok := claimFromClaimRef(&composite); !ok || claim.Namespace != namespace {
+				continue
+			}
+			seen[string(composite.GetUID())] = true
+
+			var composition Composition
+			h.walkComposite(ctx, &composite, &composition, seen, 0)
+			compositions = append(compositions, composition)
+		}
+	}
+
+	log.V(1).Info("Found compositions for namespace", "namespace", namespace, "count", len(compositions))
+	return compositions, nil
+}
+
+// walkComposite adds a composite and everything it composes to a composition, descending
+// into nested composites through their spec.resourceRefs
+func (h *CrossplaneHandler) walkComposite(
+	ctx context.Context,
+	composite *unstructured.Unstructured,
+	composition *Composition,
+	seen map[string]bool,
+	depth int,
+) {
+	composition.Composites = append(composition.Composites, *composite)
+	if depth >= maxCompositeDepth {
+		log.Info("Composite nesting too deep, not descending further",
+			"composite", fmt.Sprintf("%s/%s", composite.GetKind(), composite.GetName()))
+		return
+	}
+
+	refs, _, _ := unstructured.NestedSlice(composite.Object, "spec", "resourceRefs")
+	for _, ref := range refs {
+		refMap, ok := ref.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		resource, ok := h.getRef(ctx, refMap)
+		if !ok || seen[string(resource.GetUID())] {
+			continue
+		}
+		seen[string(resource.GetUID())] = true
+
+		if h.isComposite(resource.GroupVersionKind()) {
+			h.walkComposite(ctx, resource, composition, seen, depth+1)
+		} else {
+			composition.Resources = append(composition.Resources, *resource)
+		}
+	}
+}
+
+// resolveRef returns the cluster-scoped resource referenced by an object reference field
+func (h *CrossplaneHandler) resolveRef(ctx context.Context, obj *unstructured.Unstructured, fields ...string) (*unstructured.Unstructured, bool) {
+	ref, ok, _ := unstructured.NestedMap(obj.Object, fields...)
+	if !ok {
+		return nil, false
+	}
+	return h.getRef(ctx, ref)
+}
+
+// getRef returns the cluster-scoped resource referenced by an apiVersion, kind and name,
+// from the cache when possible
+func (h *CrossplaneHandler) getRef(ctx context.Context, ref map[string]interface{}) (*unstructured.Unstructured, bool) {
+	apiVersion, _ := ref["apiVersion"].(string)
+	kind, _ := ref["kind"].(string)
+	name, _ := ref["name"].(string)
+	if apiVersion == "" || kind == "" || name == "" {
+		// Composed resources are referenced before their name is known
+		return nil, false
+	}
+
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, false
+	}
+	gvr, err := h.resourceFor(gv.WithKind(kind))
+	if err != nil {
+		log.V(1).Info("Failed to resolve referenced resource", "kind", kind, "error", err.Error())
+		return nil, false
+	}
+
+	if resource, ok := h.cache.get(gvr, "", name); ok {
+		return resource, true
+	}
+	resource, err := h.dynamicClient.Resource(gvr).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		log.V(1).Info("Failed to get referenced resource",
+			"resource", fmt.Sprintf("%s/%s", kind, name),
+			"error", err.Error())
+		return nil, false
+	}
+	return resource, true
+}
+
+// isComposite reports whether a kind is a discovered composite resource type
+func (h *CrossplaneHandler) isComposite(gvk schema.GroupVersionKind) bool {
+	composites, _ := h.composites.list()
+	for _, t := range composites {
+		if t.GVR.Group == gvk.Group && t.Kind == gvk.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+// listNamespaced lists the resources of a type in a namespace, from the cache when
+// possible. Leave the namespace empty to list across all namespaces.
+func (h *CrossplaneHandler) listNamespaced(ctx context.Context, gvr schema.GroupVersionResource, namespace string) []unstructured.Unstructured {
+	if cached, ok := h.cache.list(gvr); ok {
+		var resources []unstructured.Unstructured
+		for _, resource := range cached {
+			if namespace == "" || resource.GetNamespace() == namespace {
+				resources = append(resources, resource)
+			}
+		}
+		return resources
+	}
+
+	list, err := h.dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Error(err, "Failed to list resources", "gvr", gvr.String(), "namespace", namespace)
+		return nil
+	}
+	return list.Items
+}
@@ -0,0 +1,147 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// connectionSecretConfidence is the confidence of a match backed by a pod consuming the
+// connection secret of a resource
+const connectionSecretConfidence = 0.95
+
+// connectionSecretKey returns the namespace/name of the connection secret a resource
+// writes through spec.writeConnectionSecretToRef
+func connectionSecretKey(resource *unstructured.Unstructured) (string, bool) {
+	name, _, _ := unstructured.NestedString(resource.Object, "spec", "writeConnectionSecretToRef", "name")
+	namespace, _, _ := unstructured.NestedString(resource.Object, "spec", "writeConnectionSecretToRef", "namespace")
+	if name == "" || namespace == "" {
+		return "", false
+	}
+	return namespace + "/" + name, true
+}
+
+// podSecretRefs returns the Secrets a pod consumes, mapped to how they are consumed:
+// volumes, projected volumes, envFrom and secretKeyRef env vars
+func podSecretRefs(pod *corev1.Pod) map[string]string {
+	refs := make(map[string]string)
+	add := func(name, how string) {
+		if _, ok := refs[name]; name != "" && !ok {
+			refs[name] = how
+		}
+	}
+
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Secret != nil {
+			add(volume.Secret.SecretName, fmt.Sprintf("volume %s", volume.Name))
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil {
+					add(source.Secret.Name, fmt.Sprintf("projected volume %s", volume.Name))
+				}
+			}
+		}
+	}
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				add(envFrom.SecretRef.Name, fmt.Sprintf("envFrom in container %s", container.Name))
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				add(env.ValueFrom.SecretKeyRef.Name, fmt.Sprintf("env %s in container %s", env.Name, container.Name))
+			}
+		}
+	}
+
+	return refs
+}
+
+// findConnectionSecretConsumers returns evidence for the resources whose connection secret
+// is consumed by a pod of the namespace
+func (h *CrossplaneHandler) findConnectionSecretConsumers(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	nsCtx *NamespaceContext,
+) []Evidence {
+	// Collect the Secrets consumed in the namespace, keeping the first consumer of each
+	consumers := make(map[string]string)
+	for i := range nsCtx.Pods {
+		pod := &nsCtx.Pods[i]
+		for name, how := range podSecretRefs(pod) {
+			key := nsCtx.Namespace + "/" + name
+			if _, ok := consumers[key]; !ok {
+				consumers[key] = fmt.Sprintf("pod %s (%s)", pod.Name, how)
+			}
+		}
+	}
+	if len(consumers) == 0 {
+		return nil
+	}
+
+	secretKeys := make([]string, 0, len(consumers))
+	for key := range consumers {
+		secretKeys = append(secretKeys, key)
+	}
+	sort.Strings(secretKeys)
+
+	writers := h.connectionSecretWriters(ctx, resourceTypes, secretKeys)
+
+	var evidence []Evidence
+	for _, key := range secretKeys {
+		for _, resource := range writers[key] {
+			evidence = append(evidence, Evidence{
+				Resource:   resource,
+				Confidence: connectionSecretConfidence,
+				Reason:     fmt.Sprintf("Connection Secret %s is consumed by %s", key, consumers[key]),
+			})
+		}
+	}
+	return evidence
+}
+
+// connectionSecretWriters returns the resources of the given types writing each of the
+// connection secrets, looked up in the cache's connection secret index for the types that
+// are cached and scanned for the others
+func (h *CrossplaneHandler) connectionSecretWriters(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	secretKeys []string,
+) map[string][]unstructured.Unstructured {
+	cached, uncached := h.cache.partition(resourceTypes)
+	writers := h.scanConnectionSecretWriters(ctx, uncached, secretKeys)
+	for _, key := range secretKeys {
+		writers[key] = append(writers[key], h.cache.byIndex(cached, connectionSecretIndex, key)...)
+	}
+	return writers
+}
+
+// scanConnectionSecretWriters finds the writers of the connection secrets by scanning
+// every resource of types that are not cached yet
+func (h *CrossplaneHandler) scanConnectionSecretWriters(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	secretKeys []string,
+) map[string][]unstructured.Unstructured {
+	wanted := make(map[string]bool, len(secretKeys))
+	for _, key := range secretKeys {
+		wanted[key] = true
+	}
+
+	writers := make(map[string][]unstructured.Unstructured, len(secretKeys))
+	for _, t := range resourceTypes {
+		for _, resource := range h.listNamespaced(ctx, t.GVR, "") {
+			if key, ok := connectionSecretKey(&resource); ok && wanted[key] {
+				writers[key] = append(writers[key], resource)
+			}
+		}
+	}
+	return writers
+}
@@ -2,17 +2,27 @@ package crossplane
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
@@ -24,6 +34,27 @@ type ResourceIdentifier struct {
 	Kind string
 	Name string
 	GVK  schema.GroupVersionKind
+	// GVR is the resource the identifier was listed from
+	GVR schema.GroupVersionResource
+}
+
+// FindOptions tunes how resources are searched for a namespace
+type FindOptions struct {
+	// ResourceTypes selects which discovered managed resource types are searched
+	ResourceTypes ResourceTypeFilter
+
+	// Detectors finds the evidence for each namespace (default: DefaultDetectorRegistry)
+	Detectors *DetectorRegistry
+
+	// DetectorSettings enables, disables and weighs detectors by name
+	DetectorSettings map[string]DetectorSettings
+
+	// Threshold is the confidence a match must exceed (default: DefaultThreshold)
+	Threshold *float64
+
+	// IgnoredLabels are the label keys the caller writes onto the resources it attributes.
+	// They are not evidence, or an attribution would confirm itself on the next pass.
+	IgnoredLabels []string
 }
 
 // ResourceMatch represents a potential match between a namespace and a resource
@@ -38,10 +69,36 @@ type CrossplaneHandler struct {
 	dynamicClient dynamic.Interface
 	projectID     string
 	mockMode      bool
+	// RESTMapper backed by API discovery, used to resolve kinds to resources
+	mapper *restmapper.DeferredDiscoveryRESTMapper
+	// Managed resource types discovered from CRDs
+	types *resourceTypeRegistry
+	// Composite resource types discovered from CRDs
+	composites *resourceTypeRegistry
+	// Claim types discovered from CRDs
+	claims *resourceTypeRegistry
+	// Informer-backed cache of managed resources, composites and claims
+	cache *resourceCache
+	// Limits discovery to the resource types RBAC lets the controller watch
+	access *accessChecker
+	// Guards the network map, which is only used while the cache is not synced
+	networkMu sync.Mutex
 	// Map of IP addresses to resource identifiers for network-based detection
 	resourceIPMap map[string][]ResourceIdentifier
 	// Last time the network map was built
 	lastNetworkMapBuild time.Time
+	// Which pods held each IP and when, recorded from pod events
+	leases *ipLeaseHistory
+	// Since when each cached resource has held each of its IPs
+	observations *ipObservations
+	// Traffic ingested from flow logs
+	flows *flowStore
+	// Resolves hostnames in resource endpoints for network-based detection
+	resolverMu sync.Mutex
+	resolver   Resolver
+	// Private IPs the endpoint hostnames of cached resources resolve to
+	endpointsMu sync.Mutex
+	endpoints   *resolvedEndpoints
 }
 
 // NewCrossplaneHandler creates a new Crossplane handler
@@ -76,16 +133,65 @@ func NewCrossplaneHandler(projectID string) (*CrossplaneHandler, error) {
 		}
 	}
 
-	return &CrossplaneHandler{
+	var access *accessChecker
+	if !mockMode {
+		authorizationClient, err := authorizationv1client.NewForConfig(config)
+		if err != nil {
+			log.Error(err, "Failed to create authorization client, resource types are not checked for access")
+		} else {
+			access = newAccessChecker(authorizationClient.SelfSubjectAccessReviews())
+		}
+	}
+
+	var mapper *restmapper.DeferredDiscoveryRESTMapper
+	if !mockMode {
+		discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+		if err != nil {
+			log.Error(err, "Failed to create discovery client, falling back to mock mode")
+			mockMode = true
+		} else {
+			mapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+		}
+	}
+
+	h := &CrossplaneHandler{
 		dynamicClient:       dynamicClient,
+		mapper:              mapper,
+		access:              access,
+		types:               &resourceTypeRegistry{types: make(map[string]ManagedResourceType)},
+		composites:          &resourceTypeRegistry{types: make(map[string]ManagedResourceType)},
+		claims:              &resourceTypeRegistry{types: make(map[string]ManagedResourceType)},
 		projectID:           projectID,
 		mockMode:            mockMode,
 		resourceIPMap:       make(map[string][]ResourceIdentifier),
 		lastNetworkMapBuild: time.Time{},
-	}, nil
+		leases:              newIPLeaseHistory(),
+		observations:        newIPObservations(),
+		flows:               newFlowStore(),
+		resolver:            NewCachingResolver(nil),
+	}
+	h.cache = newResourceCache(dynamicClient, h.extractIPAddresses, h.serviceAccountIndexKeys)
+	h.cache.addEventHandler(h.ipObservationHandler())
+	return h, nil
 }
 
-// GetCrossplaneResourceTypes returns the list of supported Crossplane resource types
+// resourceFor resolves the resource serving a kind through API discovery. Discovery
+// results are cached, so the cache is refreshed once when the kind is not found in it,
+// for instance because its CRD was installed after the cache was filled.
+func (h *CrossplaneHandler) resourceFor(gvk schema.GroupVersionKind) (schema.GroupVersionResource, error) {
+	mapping, err := h.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		h.mapper.Reset()
+		mapping, err = h.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return schema.GroupVersionResource{}, fmt.Errorf("failed to resolve resource for %s: %v", gvk.String(), err)
+	}
+	return mapping.Resource, nil
+}
+
+// GetCrossplaneResourceTypes returns a built-in list of common Crossplane resource types,
+// used when the managed resource CRDs cannot be discovered
 func GetCrossplaneResourceTypes() []schema.GroupVersionResource {
 	return []schema.GroupVersionResource{
 		// Upbound provider resources - Compute
@@ -104,7 +210,6 @@ func GetCrossplaneResourceTypes() []schema.GroupVersionResource {
 
 		// Upbound provider resources - SQL
 		{Group: "sql.gcp.upbound.io", Version: "v1beta1", Resource: "databaseinstances"},
-		{Group: "sql.gcp.upbound.io", Version: "v1beta2", Resource: "databaseinstances"},
 		{Group: "sql.gcp.upbound.io", Version: "v1beta1", Resource: "databases"},
 		{Group: "sql.gcp.upbound.io", Version: "v1beta1", Resource: "users"},
 		{Group: "sql.gcp.upbound.io", Version: "v1beta1", Resource: "sslcerts"},
@@ -135,6 +240,12 @@ func GetCrossplaneResourceTypes() []schema.GroupVersionResource {
 		// Upbound provider resources - GCS
 		{Group: "cloudscheduler.gcp.upbound.io", Version: "v1beta1", Resource: "jobs"},
 
+		// Upbound provider resources - Artifact Registry
+		{Group: "artifact.gcp.upbound.io", Version: "v1beta1", Resource: "repositories"},
+
+		// Upbound provider resources - Secret Manager
+		{Group: "secretmanager.gcp.upbound.io", Version: "v1beta1", Resource: "secrets"},
+
 		// Upbound provider resources - IAM
 		{Group: "iam.gcp.upbound.io", Version: "v1beta1", Resource: "serviceaccounts"},
 		{Group: "iam.gcp.upbound.io", Version: "v1beta1", Resource: "serviceaccountkeys"},
@@ -147,8 +258,8 @@ func GetCrossplaneResourceTypes() []schema.GroupVersionResource {
 }
 
 // FindCrossplaneResourcesForNamespace finds Crossplane resources for a specific namespace
-func (h *CrossplaneHandler) FindCrossplaneResourcesForNamespace(ctx context.Context, namespace string) ([]unstructured.Unstructured, error) {
-	matches, err := h.FindCrossplaneResourcesForNamespaceWithConfidence(ctx, namespace)
+func (h *CrossplaneHandler) FindCrossplaneResourcesForNamespace(ctx context.Context, namespace string, opts FindOptions) ([]unstructured.Unstructured, error) {
+	matches, err := h.FindCrossplaneResourcesForNamespaceWithConfidence(ctx, namespace, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -163,66 +274,8 @@ func (h *CrossplaneHandler) FindCrossplaneResourcesForNamespace(ctx context.Cont
 }
 
 // FindCrossplaneResourcesForNamespaceWithConfidence finds Crossplane resources for a specific namespace with confidence scoring
-func (h *CrossplaneHandler) FindCrossplaneResourcesForNamespaceWithConfidence(ctx context.Context, namespace string) ([]ResourceMatch, error) {
-	if h.mockMode {
-		log.Info("Mock mode: Finding Crossplane resources for namespace", "namespace", namespace)
-		return []ResourceMatch{}, nil
-	}
-
-	// Ensure network map is built and up-to-date
-	if time.Since(h.lastNetworkMapBuild) > 30*time.Minute {
-		if err := h.BuildNetworkMap(ctx); err != nil {
-			log.Error(err, "Failed to build network map", "namespace", namespace)
-		}
-	}
-
-	// Get list of resource types
-	resourceTypes := GetCrossplaneResourceTypes()
-
-	var matches []ResourceMatch
-	foundResources := make(map[string]bool)
-
-	// First pass: Look for direct matches based on metadata
-	for _, gvr := range resourceTypes {
-		list, err := h.dynamicClient.Resource(gvr).List(ctx, metav1.ListOptions{})
-		if err != nil {
-			log.Error(err, "Failed to list resources", "gvr", gvr.String())
-			continue
-		}
-
-		for _, item := range list.Items {
-			resourceKey := fmt.Sprintf("%s/%s", item.GetKind(), item.GetName())
-			if foundResources[resourceKey] {
-				continue
-			}
-
-			// Evaluate match confidence
-			matchReasons, confidence := evaluateResourceMatchForNamespace(&item, namespace)
-
-			// Only include resources with reasonable confidence
-			if confidence > 0.3 {
-				match := ResourceMatch{
-					Resource:        item,
-					ConfidenceScore: confidence,
-					MatchReasons:    matchReasons,
-				}
-				matches = append(matches, match)
-				foundResources[resourceKey] = true
-
-				log.V(1).Info("Found resource for namespace",
-					"resource", resourceKey,
-					"namespace", namespace,
-					"confidence", confidence,
-					"reasons", strings.Join(matchReasons, ", "))
-			}
-		}
-	}
-
-	// Sort matches by confidence score (highest first)
-	sortMatchesByConfidence(matches)
-
-	log.Info("Found resources for namespace", "namespace", namespace, "count", len(matches))
-	return matches, nil
+func (h *CrossplaneHandler) FindCrossplaneResourcesForNamespaceWithConfidence(ctx context.Context, namespace string, opts FindOptions) ([]ResourceMatch, error) {
+	return h.FindCrossplaneResourcesForNamespaceContext(ctx, &NamespaceContext{Namespace: namespace}, opts)
 }
 
 // sortMatchesByConfidence sorts the resource matches by confidence score (highest first)
@@ -237,180 +290,160 @@ func sortMatchesByConfidence(matches []ResourceMatch) {
 	}
 }
 
-// evaluateResourceMatchForNamespace evaluates how likely a resource is associated with a namespace
-func evaluateResourceMatchForNamespace(resource *unstructured.Unstructured, namespace string) ([]string, float64) {
-	var matchReasons []string
-	var scores []float64
-
-	// Check resource name contains namespace (strong indicator)
-	if strings.Contains(strings.ToLower(resource.GetName()), strings.ToLower(namespace)) {
-		matchReasons = append(matchReasons, fmt.Sprintf("Resource name contains namespace: %s", namespace))
-		scores = append(scores, 0.8)
+// FindCrossplaneResourcesForWorkload finds Crossplane resources for a specific workload
+func (h *CrossplaneHandler) FindCrossplaneResourcesForWorkload(ctx context.Context, workloadName string) ([]unstructured.Unstructured, error) {
+	if h.mockMode {
+		log.Info("Mock mode: Finding Crossplane resources for workload", "workload", workloadName)
+		return []unstructured.Unstructured{}, nil
 	}
 
-	// Check if the resource has namespace labels
-	if labels := resource.GetLabels(); labels != nil {
-		// Direct namespace label match (strongest indicator)
-		if ns, ok := labels["kubernetes-namespace"]; ok && ns == namespace {
-			matchReasons = append(matchReasons, "Resource has 'kubernetes-namespace' label matching the namespace")
-			scores = append(scores, 0.9)
-		}
-
-		// Alternative namespace label match
-		if ns, ok := labels["namespace"]; ok && ns == namespace {
-			matchReasons = append(matchReasons, "Resource has 'namespace' label matching the namespace")
-			scores = append(scores, 0.9)
-		}
+	// Candidates of cached types are looked up by name token and workload labels, so
+	// names only match on whole tokens; resources of other types are all scanned
+	resourceTypes := h.ManagedResourceTypes(ctx, ResourceTypeFilter{})
+	candidates := h.workloadCandidates(ctx, resourceTypes, workloadName)
 
-		// Environment label might indicate namespace
-		if env, ok := labels["environment"]; ok && env == namespace {
-			matchReasons = append(matchReasons, "Resource has 'environment' label matching the namespace")
-			scores = append(scores, 0.7)
-		}
+	var resources []unstructured.Unstructured
+	foundResources := make(map[string]bool)
 
-		// Check if any label contains the namespace name
-		for key, value := range labels {
-			if key != "kubernetes-namespace" && key != "namespace" && key != "environment" &&
-				(strings.Contains(strings.ToLower(value), strings.ToLower(namespace))) {
-				matchReasons = append(matchReasons, fmt.Sprintf("Resource has label '%s' with value containing namespace", key))
-				scores = append(scores, 0.6)
-			}
+	for _, item := range candidates {
+		resourceKey := matchKey(&item)
+		if foundResources[resourceKey] {
+			continue
 		}
-	}
 
-	// Check spec for namespace references
-	if spec, ok := resource.Object["spec"].(map[string]interface{}); ok {
-		// Check forProvider section for namespace references
-		if forProvider, ok := spec["forProvider"].(map[string]interface{}); ok {
-			// Check labels within forProvider
-			if labels, ok := forProvider["labels"].(map[string]interface{}); ok {
-				if ns, ok := labels["kubernetes-namespace"].(string); ok && ns == namespace {
-					matchReasons = append(matchReasons, "Resource spec has 'kubernetes-namespace' label in forProvider.labels")
-					scores = append(scores, 0.9)
-				}
-				if ns, ok := labels["namespace"].(string); ok && ns == namespace {
-					matchReasons = append(matchReasons, "Resource spec has 'namespace' label in forProvider.labels")
-					scores = append(scores, 0.9)
-				}
-				if env, ok := labels["environment"].(string); ok && env == namespace {
-					matchReasons = append(matchReasons, "Resource spec has 'environment' label in forProvider.labels")
-					scores = append(scores, 0.7)
-				}
-			}
-
-			// Check for name patterns in other fields
-			for key, value := range forProvider {
-				if strValue, ok := value.(string); ok && strings.Contains(strings.ToLower(strValue), strings.ToLower(namespace)) {
-					matchReasons = append(matchReasons, fmt.Sprintf("Resource spec.forProvider.%s contains namespace", key))
-					scores = append(scores, 0.5)
-				}
-			}
+		if isResourceForWorkload(&item, workloadName) {
+			resources = append(resources, item)
+			foundResources[resourceKey] = true
+			log.V(1).Info("Found resource for workload",
+				"resource", resourceRef(&item),
+				"workload", workloadName)
 		}
 	}
 
-	// If we have no scores, this isn't a match
-	if len(scores) == 0 {
-		return nil, 0
-	}
+	log.Info("Found resources for workload", "workload", workloadName, "count", len(resources))
+	return resources, nil
+}
 
-	// Calculate overall confidence score
-	var totalScore float64
-	var weightedTotal float64
-	var totalWeight float64
+// workloadCandidates returns the resources that may belong to a workload. For cached
+// types, those are the resources whose name starts a token run with the workload name and
+// those with a workload label; resources of types that are not cached are all returned.
+func (h *CrossplaneHandler) workloadCandidates(ctx context.Context, resourceTypes []ManagedResourceType, workloadName string) []unstructured.Unstructured {
+	cached, uncached := h.cache.partition(resourceTypes)
+	candidates := h.listResourcesOfTypes(ctx, uncached)
 
-	for _, score := range scores {
-		// Higher scores get higher weight (exponential weighting)
-		weight := math.Pow(score, 2)
-		weightedTotal += score * weight
-		totalWeight += weight
+	tokens := nameTokens(workloadName)
+	if len(tokens) == 0 {
+		return candidates
 	}
-
-	if totalWeight > 0 {
-		totalScore = weightedTotal / totalWeight
-	} else {
-		// If no weights, use average
-		totalScore = weightedTotal / float64(len(scores))
+	for _, lookup := range []struct{ index, value string }{
+		{nameTokenIndex, tokens[0]},
+		{labelIndex, "workload-name=" + workloadName},
+		{labelIndex, "app=" + workloadName},
+	} {
+		candidates = append(candidates, h.cache.byIndex(cached, lookup.index, lookup.value)...)
 	}
-
-	return matchReasons, totalScore
+	return candidates
 }
 
-// FindCrossplaneResourcesForWorkload finds Crossplane resources for a specific workload
-func (h *CrossplaneHandler) FindCrossplaneResourcesForWorkload(ctx context.Context, workloadName string) ([]unstructured.Unstructured, error) {
+// FindChildResources finds the Crossplane resources owned, directly or transitively,
+// by the given parent resources through their owner references. The result maps
+// each parent UID to its descendants.
+func (h *CrossplaneHandler) FindChildResources(ctx context.Context, parents []unstructured.Unstructured) (map[string][]unstructured.Unstructured, error) {
 	if h.mockMode {
-		log.Info("Mock mode: Finding Crossplane resources for workload", "workload", workloadName)
-		return []unstructured.Unstructured{}, nil
+		log.Info("Mock mode: Finding child resources", "parents", len(parents))
+		return map[string][]unstructured.Unstructured{}, nil
 	}
 
-	// Get list of resource types
-	resourceTypes := GetCrossplaneResourceTypes()
-
-	var resources []unstructured.Unstructured
-	foundResources := make(map[string]bool)
-
-	for _, gvr := range resourceTypes {
-		list, err := h.dynamicClient.Resource(gvr).List(ctx, metav1.ListOptions{})
-		if err != nil {
-			log.Error(err, "Failed to list resources", "gvr", gvr.String())
-			continue
+	// Index every resource by the UIDs of its owners
+	childrenByOwner := make(map[string][]unstructured.Unstructured)
+	for _, item := range h.listResources(ctx, ResourceTypeFilter{}) {
+		for _, owner := range item.GetOwnerReferences() {
+			childrenByOwner[string(owner.UID)] = append(childrenByOwner[string(owner.UID)], item)
 		}
+	}
 
-		for _, item := range list.Items {
-			resourceKey := fmt.Sprintf("%s/%s", item.GetKind(), item.GetName())
-			if foundResources[resourceKey] {
-				continue
-			}
-
-			if isResourceForWorkload(&item, workloadName) {
-				resources = append(resources, item)
-				foundResources[resourceKey] = true
-				log.V(1).Info("Found resource for workload",
-					"resource", resourceKey,
-					"workload", workloadName)
+	// Walk the ownership graph breadth-first from each parent, guarding against cycles
+	descendants := make(map[string][]unstructured.Unstructured, len(parents))
+	for _, parent := range parents {
+		parentUID := string(parent.GetUID())
+		visited := map[string]bool{parentUID: true}
+		queue := []string{parentUID}
+		for len(queue) > 0 {
+			uid := queue[0]
+			queue = queue[1:]
+
+			for _, child := range childrenByOwner[uid] {
+				childUID := string(child.GetUID())
+				if visited[childUID] {
+					continue
+				}
+				visited[childUID] = true
+				descendants[parentUID] = append(descendants[parentUID], child)
+				queue = append(queue, childUID)
 			}
 		}
 	}
 
-	log.Info("Found resources for workload", "workload", workloadName, "count", len(resources))
-	return resources, nil
+	log.V(1).Info("Found child resources", "parents", len(parents), "withChildren", len(descendants))
+	return descendants, nil
 }
 
-// ApplyLabelsToResource updates the labels on a Crossplane resource
-func (h *CrossplaneHandler) ApplyLabelsToResource(ctx context.Context, resource unstructured.Unstructured, labels map[string]string) error {
+// ApplyLabelsToResource updates the labels on a Crossplane resource. The options select
+// whether the labels go to the Kubernetes metadata, to spec.forProvider.labels (which the
+// provider turns into GCP labels), or both.
+func (h *CrossplaneHandler) ApplyLabelsToResource(ctx context.Context, resource unstructured.Unstructured, labels map[string]string, opts LabelOptions) error {
 	if h.mockMode {
 		log.Info("Mock mode: Applying labels to resource",
 			"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()),
-			"labels", labels)
+			"labels", labels,
+			"mode", opts.Mode)
 		return nil
 	}
 
-	// Get the resource's GVR
-	gvr := schema.GroupVersionResource{
-		Group:    resource.GroupVersionKind().Group,
-		Version:  resource.GroupVersionKind().Version,
-		Resource: strings.ToLower(resource.GetKind()) + "s",
+	// Kinds without GCP labels cannot be labelled in the ForProvider mode; in the Both
+	// mode they only take metadata labels
+	field := opts.fields().Lookup(resource.GroupVersionKind())
+	if !opts.Labellable(resource.GroupVersionKind()) {
+		return fmt.Errorf("%s: %w", resource.GetKind(), ErrUnlabellable)
 	}
 
-	// Get the current resource
-	current, err := h.dynamicClient.Resource(gvr).Get(ctx, resource.GetName(), metav1.GetOptions{})
+	// Get the resource's GVR
+	gvr, err := h.resourceFor(resource.GroupVersionKind())
 	if err != nil {
-		return fmt.Errorf("failed to get resource: %v", err)
+		return err
 	}
 
-	// Update the labels
-	currentLabels := current.GetLabels()
-	if currentLabels == nil {
-		currentLabels = make(map[string]string)
+	// Get the current resource; claims are namespaced, managed resources and composites are not
+	client := h.dynamicClient.Resource(gvr).Namespace(resource.GetNamespace())
+	current, err := client.Get(ctx, resource.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get resource: %v", err)
 	}
 
 	// Track if any changes were made
 	labelsChanged := false
 
-	// Apply new labels
-	for k, v := range labels {
-		if currentValue, exists := currentLabels[k]; !exists || currentValue != v {
-			currentLabels[k] = v
-			labelsChanged = true
+	if opts.writesMetadata() && mergeMetadataLabels(current, labels) {
+		labelsChanged = true
+	}
+
+	// In the Both mode a failed provider merge still leaves the metadata labels to write,
+	// and its error is returned once they are written
+	var providerErr error
+	if opts.writesProvider() && !field.Unsupported {
+		changed, err := mergeProviderLabels(current, labels, field)
+		switch {
+		case err == nil:
+			labelsChanged = labelsChanged || changed
+		case opts.writesMetadata() && errors.Is(err, ErrUnlabellable):
+			log.V(1).Info("Skipping provider labels of resource without its label field",
+				"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()),
+				"field", field.String())
+		default:
+			providerErr = fmt.Errorf("failed to merge provider labels into %s: %w", field, err)
+			if !opts.writesMetadata() {
+				return providerErr
+			}
 		}
 	}
 
@@ -418,20 +451,19 @@ func (h *CrossplaneHandler) ApplyLabelsToResource(ctx context.Context, resource
 	if !labelsChanged {
 		log.V(1).Info("No label changes needed",
 			"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()))
-		return nil
+		return providerErr
 	}
 
-	current.SetLabels(currentLabels)
-
 	// Update the resource
-	_, err = h.dynamicClient.Resource(gvr).Update(ctx, current, metav1.UpdateOptions{})
+	_, err = client.Update(ctx, current, metav1.UpdateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to update resource: %v", err)
 	}
 
 	log.Info("Successfully updated resource labels",
-		"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()))
-	return nil
+		"resource", fmt.Sprintf("%s/%s", resource.GetKind(), resource.GetName()),
+		"mode", opts.Mode)
+	return providerErr
 }
 
 // BuildNetworkMap builds a map of IP addresses to resources for network-based detection
@@ -443,9 +475,10 @@ func (h *CrossplaneHandler) BuildNetworkMap(ctx context.Context) error {
 
 	log.Info("Building network map for resource detection")
 	resourceMap := make(map[string][]ResourceIdentifier)
-	resourceTypes := GetCrossplaneResourceTypes()
+	resourceTypes := h.ManagedResourceTypes(ctx, ResourceTypeFilter{})
 
-	for _, gvr := range resourceTypes {
+	for _, resourceType := range resourceTypes {
+		gvr := resourceType.GVR
 		list, err := h.dynamicClient.Resource(gvr).List(ctx, metav1.ListOptions{})
 		if err != nil {
 			log.Error(err, "Failed to list resources for network map", "gvr", gvr.String())
@@ -453,14 +486,19 @@ func (h *CrossplaneHandler) BuildNetworkMap(ctx context.Context) error {
 		}
 
 		for _, item := range list.Items {
-			// Extract IP addresses from the resource
+			// Extract IP addresses from the resource, and resolve the hostnames of its endpoints
 			ipAddresses := h.extractIPAddresses(&item)
+			for _, host := range extractHostnames(&item) {
+				ipAddresses = append(ipAddresses, h.resolvePrivate(ctx, host)...)
+			}
+			ipAddresses = dedupe(ipAddresses)
 			if len(ipAddresses) > 0 {
 				gvk := item.GroupVersionKind()
 				identifier := ResourceIdentifier{
 					Kind: item.GetKind(),
 					Name: item.GetName(),
 					GVK:  gvk,
+					GVR:  gvr,
 				}
 
 				// Map each IP to this resource
@@ -471,6 +509,8 @@ func (h *CrossplaneHandler) BuildNetworkMap(ctx context.Context) error {
 		}
 	}
 
+	h.networkMu.Lock()
+	defer h.networkMu.Unlock()
 	h.resourceIPMap = resourceMap
 	h.lastNetworkMapBuild = time.Now()
 	log.Info("Network map built successfully", "ipCount", len(resourceMap))
@@ -630,142 +670,291 @@ func (h *CrossplaneHandler) extractIPAddresses(resource *unstructured.Unstructur
 		}
 	}
 
-	return ipAddresses
+	return canonicalIPs(ipAddresses)
 }
 
-// looksLikeIP checks if a string appears to be an IP address
+// looksLikeIP checks if a string is an IPv4 or IPv6 address
 func (h *CrossplaneHandler) looksLikeIP(s string) bool {
-	// Very basic check - does it have the right number of dots?
-	if strings.Count(s, ".") != 3 {
-		return false
+	_, ok := parseIP(s)
+	return ok
+}
+
+// parseIP parses an IPv4 or IPv6 address, unwrapping IPv4-mapped IPv6 addresses and
+// dropping any zone so the same address always has the same form
+func parseIP(s string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, false
 	}
+	return addr.Unmap().WithZone(""), true
+}
 
-	// Does it match a common IP pattern?
-	parts := strings.Split(s, ".")
-	if len(parts) != 4 {
-		return false
+// canonicalIPs returns the valid addresses among the values in canonical form, without
+// duplicates, so they compare equal to pod IPs however they were written
+func canonicalIPs(values []string) []string {
+	ips := make([]string, 0, len(values))
+	for _, value := range values {
+		if addr, ok := parseIP(value); ok {
+			ips = append(ips, addr.String())
+		}
 	}
+	return dedupe(ips)
+}
+
+// extractIPsFromConnectionString extracts IP addresses from a connection string such as
+// postgres://user@10.0.0.5:5432/db or [2001:db8::5]:6379
+func (h *CrossplaneHandler) extractIPsFromConnectionString(connStr string) []string {
+	var ips []string
 
-	// Check each octet is numeric
+	// Split on the delimiters that never occur inside an address, then strip ports
+	parts := strings.FieldsFunc(connStr, func(r rune) bool {
+		return strings.ContainsRune("/@,;?&= \t", r)
+	})
 	for _, part := range parts {
-		if len(part) == 0 || len(part) > 3 {
-			return false
+		if addr, ok := parseIP(part); ok {
+			ips = append(ips, addr.String())
+			continue
 		}
-		for _, c := range part {
-			if c < '0' || c > '9' {
-				return false
+		if addrPort, err := netip.ParseAddrPort(part); err == nil {
+			ips = append(ips, addrPort.Addr().Unmap().String())
+		}
+	}
+
+	return ips
+}
+
+// hostnameFields are the spec.forProvider and status.atProvider fields holding the endpoint
+// of a resource, either as a hostname or inside a connection string
+var hostnameFields = []string{"host", "endpoint", "dnsName", "uri", "connectionString"}
+
+// extractHostnames returns the lowercase hostnames found in the endpoint fields of a resource
+func extractHostnames(resource *unstructured.Unstructured) []string {
+	var hosts []string
+	for _, section := range [][]string{{"spec", "forProvider"}, {"status", "atProvider"}} {
+		fields, _, _ := unstructured.NestedMap(resource.Object, section...)
+		for _, field := range hostnameFields {
+			if value, ok := fields[field].(string); ok && value != "" {
+				hosts = append(hosts, hostnamesFromConnectionString(value)...)
 			}
 		}
 	}
+	return dedupe(hosts)
+}
+
+// hostnamesFromConnectionString extracts hostnames from a connection string such as
+// redis://cache.internal.example.com:6379 or db.example.internal:5432
+func hostnamesFromConnectionString(connStr string) []string {
+	var hosts []string
+	if u, err := url.Parse(connStr); err == nil && u.Host != "" {
+		if host := u.Hostname(); looksLikeHostname(host) {
+			hosts = append(hosts, strings.ToLower(host))
+		}
+	}
 
-	return true
+	parts := strings.FieldsFunc(connStr, func(r rune) bool {
+		return strings.ContainsRune("/@,;?&= \t", r)
+	})
+	for _, part := range parts {
+		host := part
+		if withoutPort, _, err := net.SplitHostPort(part); err == nil {
+			host = withoutPort
+		}
+		if looksLikeHostname(host) {
+			hosts = append(hosts, strings.ToLower(strings.TrimSuffix(host, ".")))
+		}
+	}
+	return dedupe(hosts)
 }
 
-// extractIPsFromConnectionString extracts IP addresses from a connection string
-func (h *CrossplaneHandler) extractIPsFromConnectionString(connStr string) []string {
-	var ips []string
+// looksLikeHostname checks if a string is a fully qualified DNS name rather than an IP
+// address, a single label or a URL scheme
+func looksLikeHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if len(s) == 0 || len(s) > 253 || !strings.Contains(s, ".") {
+		return false
+	}
+	if _, ok := parseIP(s); ok {
+		return false
+	}
 
-	// Look for common patterns in connection strings
-	// 1. Split by common delimiters
-	for _, delimiter := range []string{":", "/", "@", ","} {
-		parts := strings.Split(connStr, delimiter)
-		for _, part := range parts {
-			// Clean up the part
-			part = strings.TrimSpace(part)
-			if h.looksLikeIP(part) {
-				ips = append(ips, part)
+	labels := strings.Split(s, ".")
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' {
+				return false
 			}
 		}
 	}
 
-	return ips
+	// Top-level domains are never numeric
+	tld := labels[len(labels)-1]
+	return strings.Trim(tld, "0123456789") != ""
 }
 
-// FindCrossplaneResourcesForNamespaceWithNetworking enhances resource finding with network detection
+// FindCrossplaneResourcesForNamespaceWithNetworking finds Crossplane resources for a
+// namespace whose pods hold the given IPs
 func (h *CrossplaneHandler) FindCrossplaneResourcesForNamespaceWithNetworking(
 	ctx context.Context,
 	namespace string,
 	podIPs []string,
+	opts FindOptions,
 ) ([]ResourceMatch, error) {
-	// First get matches based on metadata
-	matches, err := h.FindCrossplaneResourcesForNamespaceWithConfidence(ctx, namespace)
-	if err != nil {
-		return nil, err
+	nsCtx := &NamespaceContext{Namespace: namespace}
+	for _, ip := range podIPs {
+		nsCtx.Pods = append(nsCtx.Pods, corev1.Pod{Status: corev1.PodStatus{PodIP: ip}})
+	}
+	return h.FindCrossplaneResourcesForNamespaceContext(ctx, nsCtx, opts)
+}
+
+// podAddressConfidence is the confidence of a resource holding the IP of a pod
+const podAddressConfidence = 0.9
+
+// findPodAddresses matches the resources holding an IP of a pod of the namespace
+func (h *CrossplaneHandler) findPodAddresses(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	nsCtx *NamespaceContext,
+) []Evidence {
+	var evidence []Evidence
+	for _, podIP := range nsCtx.PodIPs() {
+		addr, ok := parseIP(podIP)
+		if !ok {
+			continue
+		}
+		podIP = addr.String()
+
+		for _, holder := range h.resourcesForIP(ctx, resourceTypes, podIP) {
+			resource := holder.resource
+
+			// The resource must have held the IP while a pod of the namespace did, or the
+			// IP may have been recycled from another namespace
+			from, to := h.ipObservationInterval(&resource, podIP, holder.snapshot)
+			if !h.leases.heldBy(podIP, nsCtx.Namespace, from, to) {
+				log.V(1).Info("Skipping network match outside the namespace's lease on the IP",
+					"podIP", podIP,
+					"resource", resourceRef(&resource),
+					"namespace", nsCtx.Namespace)
+				continue
+			}
+
+			log.Info("Found resource sharing a pod IP",
+				"podIP", podIP,
+				"resource", resourceRef(&resource),
+				"namespace", nsCtx.Namespace)
+
+			// Sharing an address is not observed traffic, which comes from flow logs
+			evidence = append(evidence, Evidence{
+				Resource:   resource,
+				Confidence: podAddressConfidence,
+				Reason:     fmt.Sprintf("Pod IP %s is an address of the resource", podIP),
+			})
+		}
 	}
+	return evidence
+}
+
+// ipHolder is a resource found holding an IP address, with the time of the network map
+// snapshot it was found in, or the zero time when it was found in the cache
+type ipHolder struct {
+	resource unstructured.Unstructured
+	snapshot time.Time
+}
 
-	// Skip network-based detection if no pod IPs or mock mode
-	if len(podIPs) == 0 || h.mockMode {
-		return matches, nil
+// resourcesForIP returns the resources of the given types with an IP address. Resources of
+// cached types are looked up in the cache's IP index; for types that are not cached yet, a
+// periodically rebuilt network map is used instead.
+func (h *CrossplaneHandler) resourcesForIP(ctx context.Context, resourceTypes []ManagedResourceType, ip string) []ipHolder {
+	cached, uncached := h.cache.partition(resourceTypes)
+
+	var holders []ipHolder
+	if len(cached) > 0 {
+		resources := h.cache.byIndex(cached, ipIndex, ip)
+		resources = append(resources, h.resourcesForResolvedIP(ctx, cached, ip, resources)...)
+		for _, resource := range resources {
+			holders = append(holders, ipHolder{resource: resource})
+		}
+	}
+	if len(uncached) == 0 {
+		return holders
 	}
 
 	// Ensure network map is built
-	if len(h.resourceIPMap) == 0 || time.Since(h.lastNetworkMapBuild) > 30*time.Minute {
+	h.networkMu.Lock()
+	stale := len(h.resourceIPMap) == 0 || time.Since(h.lastNetworkMapBuild) > 30*time.Minute
+	h.networkMu.Unlock()
+	if stale {
 		if err := h.BuildNetworkMap(ctx); err != nil {
 			log.Error(err, "Failed to build network map for network-based detection")
 		}
 	}
 
-	// Track resources we've already found
-	foundResources := make(map[string]bool)
-	for _, match := range matches {
-		resourceKey := fmt.Sprintf("%s/%s",
-			match.Resource.GetKind(),
-			match.Resource.GetName())
-		foundResources[resourceKey] = true
+	selectedTypes := make(map[schema.GroupVersionResource]bool, len(uncached))
+	for _, resourceType := range uncached {
+		selectedTypes[resourceType.GVR] = true
 	}
 
-	// Check each pod IP against the network map
-	for _, podIP := range podIPs {
-		connectedResources, found := h.resourceIPMap[podIP]
-		if !found {
+	h.networkMu.Lock()
+	identifiers := h.resourceIPMap[ip]
+	snapshot := h.lastNetworkMapBuild
+	h.networkMu.Unlock()
+
+	for _, identifier := range identifiers {
+		if !selectedTypes[identifier.GVR] {
 			continue
 		}
 
-		for _, connectedResource := range connectedResources {
-			resourceKey := fmt.Sprintf("%s/%s",
-				connectedResource.Kind,
-				connectedResource.Name)
-
-			// Skip if we've already found this resource
-			if foundResources[resourceKey] {
-				continue
-			}
-
-			// Get the resource
-			gvr := schema.GroupVersionResource{
-				Group:    connectedResource.GVK.Group,
-				Version:  connectedResource.GVK.Version,
-				Resource: strings.ToLower(connectedResource.Kind) + "s", // Simplification
-			}
+		// Get the resource from the same resource it was listed from
+		resource, err := h.dynamicClient.Resource(identifier.GVR).Get(ctx, identifier.Name, metav1.GetOptions{})
+		if err != nil {
+			log.Error(err, "Failed to get resource for network match",
+				"resource", fmt.Sprintf("%s/%s", identifier.Kind, identifier.Name))
+			continue
+		}
+		holders = append(holders, ipHolder{resource: *resource, snapshot: snapshot})
+	}
+	return holders
+}
 
-			resource, err := h.dynamicClient.Resource(gvr).Get(ctx, connectedResource.Name, metav1.GetOptions{})
-			if err != nil {
-				log.Error(err, "Failed to get resource for network match",
-					"resource", resourceKey)
-				continue
-			}
+// resourcesForResolvedIP returns the cached resources of the given types whose endpoint
+// hostnames resolve to an IP address, leaving out resources already found
+func (h *CrossplaneHandler) resourcesForResolvedIP(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	ip string,
+	found []unstructured.Unstructured,
+) []unstructured.Unstructured {
+	seen := make(map[string]bool, len(found))
+	for _, resource := range found {
+		seen[string(resource.GetUID())] = true
+	}
 
-			log.Info("Found network connection between pod and resource",
-				"podIP", podIP,
-				"resource", resourceKey,
-				"namespace", namespace)
-
-			// Create a match with high confidence due to network evidence
-			match := ResourceMatch{
-				Resource:        *resource,
-				ConfidenceScore: 0.9, // High confidence for network connections
-				MatchReasons:    []string{fmt.Sprintf("Network connection detected from pod IP %s", podIP)},
+	var resources []unstructured.Unstructured
+	for _, host := range h.hostnamesForIP(ctx, resourceTypes, ip) {
+		for _, resource := range h.cache.byIndex(resourceTypes, hostnameIndex, host) {
+			if !seen[string(resource.GetUID())] {
+				seen[string(resource.GetUID())] = true
+				resources = append(resources, resource)
 			}
-
-			matches = append(matches, match)
-			foundResources[resourceKey] = true
 		}
 	}
+	return resources
+}
 
-	// Re-sort matches by confidence
-	sortMatchesByConfidence(matches)
-
-	return matches, nil
+// ipObservationInterval returns the interval during which a resource is known to have held
+// an IP: since it was first seen with it in the cache, or the moment of the network map
+// snapshot it was found in
+func (h *CrossplaneHandler) ipObservationInterval(resource *unstructured.Unstructured, ip string, snapshot time.Time) (time.Time, time.Time) {
+	if !snapshot.IsZero() {
+		return snapshot, snapshot
+	}
+	now := time.Now()
+	if since, ok := h.observations.firstSeen(string(resource.GetUID()), ip); ok {
+		return since, now
+	}
+	return now, now
 }
 
 // isResourceForWorkload determines if a Crossplane resource is associated with a workload
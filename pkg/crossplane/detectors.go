@@ -0,0 +1,317 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Names of the built-in detectors
+const (
+	// DetectorClaim attributes resources composed for a claim in the namespace
+	DetectorClaim = "claim"
+	// DetectorName matches resource names containing the namespace name
+	DetectorName = "name"
+	// DetectorLabel matches namespace labels on the resource and in its GCP labels
+	DetectorLabel = "label"
+	// DetectorSpecField matches spec.forProvider fields containing the namespace name
+	DetectorSpecField = "spec-field"
+	// DetectorNetwork matches resources holding an IP of a pod
+	DetectorNetwork = "network"
+	// DetectorSubnet matches the subnetworks the pod IPs are allocated from
+	DetectorSubnet = "subnet"
+	// DetectorFlow matches resources flow logs show the pods sending traffic to
+	DetectorFlow = "flow"
+	// DetectorSecret matches resources whose connection Secret the pods consume
+	DetectorSecret = "secret"
+	// DetectorExternalSecret matches the Secret Manager secrets ExternalSecrets read
+	DetectorExternalSecret = "external-secret"
+	// DetectorWorkloadIdentity follows Workload Identity to GCP service accounts and their grants
+	DetectorWorkloadIdentity = "workload-identity"
+	// DetectorDisk matches the disks behind persistent volume claims
+	DetectorDisk = "disk"
+	// DetectorAddress matches the reserved addresses of Services and Ingresses
+	DetectorAddress = "address"
+	// DetectorImage matches the Artifact Registry repositories images are pulled from
+	DetectorImage = "image"
+	// DetectorReference matches resource identifiers named in env, ConfigMaps and args
+	DetectorReference = "reference"
+	// DetectorCloudSQL matches the Cloud SQL instances pods connect to
+	DetectorCloudSQL = "cloudsql"
+)
+
+// DefaultThreshold is the confidence a match must exceed to be kept
+const DefaultThreshold = 0.3
+
+// Evidence is a reason to associate a resource with a namespace
+type Evidence struct {
+	// Resource is the managed resource
+	Resource unstructured.Unstructured
+	// Confidence is how strongly the evidence ties the resource to the namespace, from 0 to 1
+	Confidence float64
+	// Reason describes the evidence
+	Reason string
+	// Family groups repeated hits of the same signal, whose combined confidence is capped
+	// (default: the name of the detector)
+	Family string
+}
+
+// Detector finds evidence associating managed resources with a namespace
+type Detector interface {
+	// Name identifies the detector in the find options and the labeller spec
+	Name() string
+
+	// Detect returns the evidence found for the namespace of the request
+	Detect(ctx context.Context, req *DetectionRequest) []Evidence
+}
+
+// DetectionRequest is what detectors search: a namespace context and the selected
+// managed resource types
+type DetectionRequest struct {
+	// Namespace is the workload state of the namespace
+	Namespace *NamespaceContext
+	// ResourceTypes are the managed resource types to search
+	ResourceTypes []ManagedResourceType
+	// IgnoredLabels are the label keys, in Kubernetes and GCP form, that are not evidence
+	// because the controller writes them
+	IgnoredLabels map[string]bool
+
+	handler   *CrossplaneHandler
+	resources []unstructured.Unstructured
+	listed    bool
+}
+
+// Resources returns the resources of the selected types. They are listed once and shared
+// by the detectors of the request.
+func (r *DetectionRequest) Resources(ctx context.Context) []unstructured.Unstructured {
+	if !r.listed {
+		r.resources = r.handler.listResourcesOfTypes(ctx, r.ResourceTypes)
+		r.listed = true
+	}
+	return r.resources
+}
+
+// ResourceDetectorFunc returns the evidence that a single resource belongs to a namespace
+type ResourceDetectorFunc func(resource *unstructured.Unstructured, nsCtx *NamespaceContext) []Evidence
+
+// resourceDetector runs a function on every resource of the selected types
+type resourceDetector struct {
+	name   string
+	detect ResourceDetectorFunc
+}
+
+// NewResourceDetector creates a detector that calls detect with each resource of the
+// selected types
+func NewResourceDetector(name string, detect ResourceDetectorFunc) Detector {
+	return &resourceDetector{name: name, detect: detect}
+}
+
+// Name implements Detector
+func (d *resourceDetector) Name() string {
+	return d.name
+}
+
+// Detect implements Detector
+func (d *resourceDetector) Detect(ctx context.Context, req *DetectionRequest) []Evidence {
+	resources := req.Resources(ctx)
+	var evidence []Evidence
+	for i := range resources {
+		evidence = append(evidence, d.detect(&resources[i], req.Namespace)...)
+	}
+	return evidence
+}
+
+// handlerDetector looks resources up from the workloads of the namespace, through the
+// indexes of the handler's cache
+type handlerDetector struct {
+	name string
+	find func(h *CrossplaneHandler, ctx context.Context, resourceTypes []ManagedResourceType, nsCtx *NamespaceContext) []Evidence
+}
+
+// Name implements Detector
+func (d *handlerDetector) Name() string {
+	return d.name
+}
+
+// Detect implements Detector
+func (d *handlerDetector) Detect(ctx context.Context, req *DetectionRequest) []Evidence {
+	return d.find(req.handler, ctx, req.ResourceTypes, req.Namespace)
+}
+
+// claimDetector attributes the resources composed for a claim in the namespace
+type claimDetector struct{}
+
+// Name implements Detector
+func (claimDetector) Name() string {
+	return DetectorClaim
+}
+
+// Detect implements Detector
+func (claimDetector) Detect(ctx context.Context, req *DetectionRequest) []Evidence {
+	resources := req.Resources(ctx)
+	var evidence []Evidence
+	for i := range resources {
+		if match, ok, _ := req.handler.claimMatch(ctx, &resources[i], req.Namespace.Namespace); ok {
+			for _, reason := range match.MatchReasons {
+				evidence = append(evidence, Evidence{
+					Resource:   match.Resource,
+					Confidence: match.ConfidenceScore,
+					Reason:     reason,
+				})
+			}
+		}
+	}
+	return evidence
+}
+
+// labelDetector matches the namespace in the labels of each resource, leaving out the
+// labels the controller writes
+type labelDetector struct{}
+
+// Name implements Detector
+func (labelDetector) Name() string {
+	return DetectorLabel
+}
+
+// Detect implements Detector
+func (labelDetector) Detect(ctx context.Context, req *DetectionRequest) []Evidence {
+	resources := req.Resources(ctx)
+	var evidence []Evidence
+	for i := range resources {
+		evidence = append(evidence, detectLabels(&resources[i], req.Namespace, req.IgnoredLabels)...)
+	}
+	return evidence
+}
+
+// DetectorRegistry holds the detectors run for each namespace, in order
+type DetectorRegistry struct {
+	detectors []Detector
+}
+
+// defaultDetectors is the shared registry of built-in detectors
+var defaultDetectors = DefaultDetectorRegistry()
+
+// NewDetectorRegistry creates an empty detector registry
+func NewDetectorRegistry() *DetectorRegistry {
+	return &DetectorRegistry{}
+}
+
+// DefaultDetectorRegistry creates a registry with the built-in detectors
+func DefaultDetectorRegistry() *DetectorRegistry {
+	r := NewDetectorRegistry()
+
+	// Signals read from the resource itself
+	r.Register(claimDetector{})
+	r.Register(NewResourceDetector(DetectorName, detectName))
+	r.Register(labelDetector{})
+	r.Register(NewResourceDetector(DetectorSpecField, detectSpecFields))
+
+	// Signals found from the workloads of the namespace
+	r.Register(&handlerDetector{name: DetectorNetwork, find: (*CrossplaneHandler).findPodAddresses})
+	r.Register(&handlerDetector{name: DetectorSecret, find: (*CrossplaneHandler).findConnectionSecretConsumers})
+	r.Register(&handlerDetector{name: DetectorWorkloadIdentity, find: (*CrossplaneHandler).findWorkloadIdentityAccess})
+	r.Register(&handlerDetector{name: DetectorDisk, find: (*CrossplaneHandler).findPersistentDisks})
+	r.Register(&handlerDetector{name: DetectorAddress, find: (*CrossplaneHandler).findStaticAddresses})
+	r.Register(&handlerDetector{name: DetectorImage, find: (*CrossplaneHandler).findImageRepositories})
+	r.Register(&handlerDetector{name: DetectorExternalSecret, find: (*CrossplaneHandler).findExternalSecrets})
+	r.Register(&handlerDetector{name: DetectorReference, find: (*CrossplaneHandler).findConfigurationReferences})
+	r.Register(&handlerDetector{name: DetectorCloudSQL, find: (*CrossplaneHandler).findCloudSQLConnections})
+	r.Register(&handlerDetector{name: DetectorSubnet, find: (*CrossplaneHandler).findSubnetworks})
+	r.Register(&handlerDetector{name: DetectorFlow, find: (*CrossplaneHandler).findFlows})
+
+	return r
+}
+
+// Register adds a detector, replacing a registered detector of the same name in place
+func (r *DetectorRegistry) Register(detector Detector) {
+	for i := range r.detectors {
+		if r.detectors[i].Name() == detector.Name() {
+			r.detectors[i] = detector
+			return
+		}
+	}
+	r.detectors = append(r.detectors, detector)
+}
+
+// Lookup returns the detector registered under a name
+func (r *DetectorRegistry) Lookup(name string) (Detector, bool) {
+	for _, detector := range r.detectors {
+		if detector.Name() == name {
+			return detector, true
+		}
+	}
+	return nil, false
+}
+
+// Detectors returns the registered detectors in order
+func (r *DetectorRegistry) Detectors() []Detector {
+	return append([]Detector(nil), r.detectors...)
+}
+
+// DetectorSettings tunes a detector
+type DetectorSettings struct {
+	// Disabled turns the detector off
+	Disabled bool
+
+	// Weight scales the confidence of the detector's evidence, which is capped at 1
+	// (default: 1). A weight of 0 runs the detector without its evidence counting.
+	Weight *float64
+}
+
+// detectors returns the detector registry to use
+func (o FindOptions) detectors() *DetectorRegistry {
+	if o.Detectors != nil {
+		return o.Detectors
+	}
+	return defaultDetectors
+}
+
+// detectorWeight returns the weight of a detector, and false when it is disabled
+func (o FindOptions) detectorWeight(name string) (float64, bool) {
+	settings := o.DetectorSettings[name]
+	if settings.Disabled {
+		return 0, false
+	}
+	if settings.Weight == nil {
+		return 1, true
+	}
+	return *settings.Weight, true
+}
+
+// threshold returns the confidence a match must exceed
+func (o FindOptions) threshold() float64 {
+	if o.Threshold != nil {
+		return *o.Threshold
+	}
+	return DefaultThreshold
+}
+
+// ignoredLabels returns the ignored label keys with the GCP form of each, as written to
+// the provider labels
+func (o FindOptions) ignoredLabels() map[string]bool {
+	ignored := make(map[string]bool, 2*len(o.IgnoredLabels))
+	for _, key := range o.IgnoredLabels {
+		ignored[key] = true
+		if gcpKey, ok := sanitizeGCPLabelKey(key); ok {
+			ignored[gcpKey] = true
+		}
+	}
+	return ignored
+}
+
+// matchKey identifies a resource among the matches of a namespace. Managed resources of
+// different API groups often share a kind and name, such as the Instance of Compute Engine,
+// Memorystore and Spanner, so resources are keyed by UID, or by group, version, kind and
+// name when they have none.
+func matchKey(resource *unstructured.Unstructured) string {
+	if uid := resource.GetUID(); uid != "" {
+		return string(uid)
+	}
+	return fmt.Sprintf("%s/%s", resource.GroupVersionKind(), resource.GetName())
+}
+
+// resourceRef names a resource in logs
+func resourceRef(resource *unstructured.Unstructured) string {
+	return fmt.Sprintf("%s.%s/%s", resource.GetKind(), resource.GroupVersionKind().Group, resource.GetName())
+}
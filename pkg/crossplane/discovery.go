@@ -0,0 +1,395 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DefaultManagedGroupSuffix is the API group suffix of the Upbound GCP provider family
+	DefaultManagedGroupSuffix = ".gcp.upbound.io"
+
+	// ManagedResourceCategory is the CRD category Crossplane assigns to managed resources
+	ManagedResourceCategory = "managed"
+
+	// CompositeResourceCategory is the CRD category Crossplane assigns to composite resources
+	CompositeResourceCategory = "composite"
+
+	// ClaimCategory is the CRD category Crossplane assigns to claims
+	ClaimCategory = "claim"
+)
+
+// crdGVR is the resource of CustomResourceDefinitions
+var crdGVR = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// ManagedResourceType describes a managed resource kind discovered from its CRD
+type ManagedResourceType struct {
+	// GVR is the resource of the CRD's storage version, or its first served version
+	GVR schema.GroupVersionResource
+	// Kind is the kind of the resource
+	Kind string
+	// Categories are the CRD categories of the resource
+	Categories []string
+}
+
+// GroupVersionKind returns the kind of the resource type at its discovered version
+func (t ManagedResourceType) GroupVersionKind() schema.GroupVersionKind {
+	return t.GVR.GroupVersion().WithKind(t.Kind)
+}
+
+// hasCategory reports whether the resource type is in a CRD category
+func (t ManagedResourceType) hasCategory(category string) bool {
+	for _, c := range t.Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// ResourceTypeFilter selects managed resource types. A type is selected when its group
+// ends with one of the group suffixes or it is in one of the categories, it matches an
+// include entry (when any are given), and it matches no exclude entry. Include and
+// exclude entries with an empty kind cover the whole group.
+type ResourceTypeFilter struct {
+	GroupSuffixes []string
+	Categories    []string
+	Include       []schema.GroupKind
+	Exclude       []schema.GroupKind
+}
+
+// Matches reports whether the filter selects a resource type
+func (f ResourceTypeFilter) Matches(t ManagedResourceType) bool {
+	groupSuffixes := f.GroupSuffixes
+	if len(groupSuffixes) == 0 && len(f.Categories) == 0 {
+		groupSuffixes = []string{DefaultManagedGroupSuffix}
+	}
+
+	selected := false
+	for _, suffix := range groupSuffixes {
+		if strings.HasSuffix(t.GVR.Group, suffix) {
+			selected = true
+			break
+		}
+	}
+	for _, category := range f.Categories {
+		if selected {
+			break
+		}
+		selected = t.hasCategory(category)
+	}
+	if !selected {
+		return false
+	}
+
+	if len(f.Include) > 0 && !matchesGroupKind(f.Include, t) {
+		return false
+	}
+	return !matchesGroupKind(f.Exclude, t)
+}
+
+// matchesGroupKind reports whether a resource type matches any of the group kinds
+func matchesGroupKind(groupKinds []schema.GroupKind, t ManagedResourceType) bool {
+	for _, gk := range groupKinds {
+		if gk.Group == t.GVR.Group && (gk.Kind == "" || gk.Kind == t.Kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceTypeRegistry tracks the managed resource types discovered from CRDs
+type resourceTypeRegistry struct {
+	mu     sync.RWMutex
+	types  map[string]ManagedResourceType // keyed by CRD name
+	synced bool
+}
+
+// set records the resource type of a CRD, or forgets it when the CRD is not a managed resource
+func (r *resourceTypeRegistry) set(name string, t ManagedResourceType, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ok {
+		r.types[name] = t
+	} else {
+		delete(r.types, name)
+	}
+}
+
+// remove forgets the resource type of a deleted CRD
+func (r *resourceTypeRegistry) remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.types, name)
+}
+
+// list returns the known resource types sorted by GVR
+func (r *resourceTypeRegistry) list() ([]ManagedResourceType, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]ManagedResourceType, 0, len(r.types))
+	for _, t := range r.types {
+		types = append(types, t)
+	}
+	sortResourceTypes(types)
+	return types, r.synced
+}
+
+// sortResourceTypes sorts resource types by GVR so listings are deterministic
+func sortResourceTypes(types []ManagedResourceType) {
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].GVR.String() < types[j].GVR.String()
+	})
+}
+
+// resourceTypeFromCRD returns the managed resource type defined by a CRD. CRDs that are
+// neither in the managed category nor in a DefaultManagedGroupSuffix group are ignored.
+func resourceTypeFromCRD(crd *unstructured.Unstructured) (ManagedResourceType, bool) {
+	t, ok := crdResourceType(crd)
+	if !ok || (!strings.HasSuffix(t.GVR.Group, DefaultManagedGroupSuffix) && !t.hasCategory(ManagedResourceCategory)) {
+		return ManagedResourceType{}, false
+	}
+	return t, true
+}
+
+// compositeTypeFromCRD returns the composite resource type defined by a CRD generated
+// from a CompositeResourceDefinition. Other CRDs are ignored.
+func compositeTypeFromCRD(crd *unstructured.Unstructured) (ManagedResourceType, bool) {
+	t, ok := crdResourceType(crd)
+	if !ok || !t.hasCategory(CompositeResourceCategory) {
+		return ManagedResourceType{}, false
+	}
+	return t, true
+}
+
+// claimTypeFromCRD returns the claim type defined by a CRD generated from a
+// CompositeResourceDefinition. Other CRDs are ignored.
+func claimTypeFromCRD(crd *unstructured.Unstructured) (ManagedResourceType, bool) {
+	t, ok := crdResourceType(crd)
+	if !ok || !t.hasCategory(ClaimCategory) {
+		return ManagedResourceType{}, false
+	}
+	return t, true
+}
+
+// crdResourceType returns the resource type defined by a CRD at its storage version,
+// falling back to the first served version
+func crdResourceType(crd *unstructured.Unstructured) (ManagedResourceType, bool) {
+	group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+	kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	plural, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "plural")
+	categories, _, _ := unstructured.NestedStringSlice(crd.Object, "spec", "names", "categories")
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if group == "" || kind == "" || plural == "" {
+		return ManagedResourceType{}, false
+	}
+
+	t := ManagedResourceType{
+		GVR:        schema.GroupVersionResource{Group: group, Resource: plural},
+		Kind:       kind,
+		Categories: categories,
+	}
+
+	// Prefer the storage version, falling back to the first served version
+	for _, v := range versions {
+		version, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := version["name"].(string)
+		served, _ := version["served"].(bool)
+		storage, _ := version["storage"].(bool)
+		if !served {
+			continue
+		}
+		if storage {
+			t.GVR.Version = name
+			break
+		}
+		if t.GVR.Version == "" {
+			t.GVR.Version = name
+		}
+	}
+	if t.GVR.Version == "" {
+		return ManagedResourceType{}, false
+	}
+
+	return t, true
+}
+
+// Start watches CustomResourceDefinitions to keep the managed resource types up to date
+// as providers are installed and removed, and runs an informer for each type so managed
+// resources are served from memory. It blocks until the context is cancelled.
+func (h *CrossplaneHandler) Start(ctx context.Context) error {
+	if h.mockMode {
+		log.Info("Mock mode: Skipping managed resource discovery")
+		<-ctx.Done()
+		return nil
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(h.dynamicClient, 0)
+	informer := factory.ForResource(crdGVR).Informer()
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    h.onCRDChange,
+		UpdateFunc: func(_, obj interface{}) { h.onCRDChange(obj) },
+		DeleteFunc: h.onCRDDelete,
+	}); err != nil {
+		return fmt.Errorf("failed to watch CustomResourceDefinitions: %v", err)
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync CustomResourceDefinitions")
+	}
+
+	for _, registry := range []*resourceTypeRegistry{h.types, h.composites, h.claims} {
+		registry.mu.Lock()
+		registry.synced = true
+		registry.mu.Unlock()
+	}
+
+	types, _ := h.types.list()
+	composites, _ := h.composites.list()
+	claims, _ := h.claims.list()
+	log.Info("Discovered managed resource types", "count", len(types), "composites", len(composites), "claims", len(claims))
+
+	// Serve managed resources, composites and claims from informers from now on
+	h.cache.start(ctx)
+	h.syncCache()
+
+	// Pick up resource types the controller has been granted access to since
+	ticker := time.NewTicker(accessRecheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			h.syncCache()
+		}
+	}
+}
+
+// onCRDChange records the managed resource type of an added or updated CRD
+func (h *CrossplaneHandler) onCRDChange(obj interface{}) {
+	crd, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	t, managed := resourceTypeFromCRD(crd)
+	h.types.set(crd.GetName(), t, managed)
+	if managed {
+		// Make sure the new kind can be resolved right away
+		h.mapper.Reset()
+		log.V(1).Info("Discovered managed resource type", "gvr", t.GVR.String(), "kind", t.Kind)
+	}
+
+	composite, isComposite := compositeTypeFromCRD(crd)
+	h.composites.set(crd.GetName(), composite, isComposite)
+	if isComposite {
+		h.mapper.Reset()
+		log.V(1).Info("Discovered composite resource type", "gvr", composite.GVR.String(), "kind", composite.Kind)
+	}
+
+	claim, isClaim := claimTypeFromCRD(crd)
+	h.claims.set(crd.GetName(), claim, isClaim)
+	if isClaim {
+		h.mapper.Reset()
+		log.V(1).Info("Discovered claim type", "gvr", claim.GVR.String(), "kind", claim.Kind)
+	}
+	h.syncCache()
+}
+
+// syncCache starts and stops informers to follow the discovered managed resource,
+// composite and claim types the controller may watch
+func (h *CrossplaneHandler) syncCache() {
+	types, synced := h.types.list()
+	composites, _ := h.composites.list()
+	claims, _ := h.claims.list()
+	if synced {
+		h.cache.sync(h.access.filter(context.Background(), append(append(types, composites...), claims...)))
+	}
+}
+
+// onCRDDelete forgets the managed resource type of a deleted CRD
+func (h *CrossplaneHandler) onCRDDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	crd, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	h.types.remove(crd.GetName())
+	h.composites.remove(crd.GetName())
+	h.claims.remove(crd.GetName())
+	log.V(1).Info("Managed resource type removed", "crd", crd.GetName())
+	h.syncCache()
+}
+
+// ManagedResourceTypes returns the discovered managed resource types selected by the filter
+// that the controller may list and watch. Until the CRD watch has synced, the CRDs are
+// listed directly, and if that fails the static GetCrossplaneResourceTypes list is used.
+func (h *CrossplaneHandler) ManagedResourceTypes(ctx context.Context, filter ResourceTypeFilter) []ManagedResourceType {
+	types, synced := h.types.list()
+	if !synced {
+		var err error
+		types, err = h.discoverResourceTypes(ctx)
+		if err != nil {
+			log.Error(err, "Failed to discover managed resource types, using the built-in list")
+			types = builtinResourceTypes()
+		}
+	}
+
+	selected := make([]ManagedResourceType, 0, len(types))
+	for _, t := range types {
+		if filter.Matches(t) {
+			selected = append(selected, t)
+		}
+	}
+	return h.access.filter(ctx, selected)
+}
+
+// discoverResourceTypes lists the CRDs and returns the managed resource types they define
+func (h *CrossplaneHandler) discoverResourceTypes(ctx context.Context) ([]ManagedResourceType, error) {
+	list, err := h.dynamicClient.Resource(crdGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var types []ManagedResourceType
+	for i := range list.Items {
+		if t, ok := resourceTypeFromCRD(&list.Items[i]); ok {
+			types = append(types, t)
+		}
+	}
+	sortResourceTypes(types)
+	return types, nil
+}
+
+// builtinResourceTypes converts the static resource type list into resource types.
+// The kinds are unknown, so include and exclude entries only match them by group.
+func builtinResourceTypes() []ManagedResourceType {
+	gvrs := GetCrossplaneResourceTypes()
+	types := make([]ManagedResourceType, 0, len(gvrs))
+	for _, gvr := range gvrs {
+		types = append(types, ManagedResourceType{GVR: gvr, Categories: []string{ManagedResourceCategory}})
+	}
+	return types
+}
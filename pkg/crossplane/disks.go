@@ -0,0 +1,156 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// gcePDCSIDriver is the name of the Compute Engine persistent disk CSI driver
+	gcePDCSIDriver = "pd.csi.storage.gke.io"
+
+	// persistentDiskConfidence is the confidence of a match backed by a bound persistent volume
+	persistentDiskConfidence = 0.95
+)
+
+// diskKinds are the compute kinds persistent volumes can be backed by
+var diskKinds = map[string]bool{
+	"Disk":       true,
+	"RegionDisk": true,
+}
+
+// persistentDisk identifies the Compute Engine disk backing a persistent volume. The
+// project and location are only known from a CSI volume handle.
+type persistentDisk struct {
+	Project string
+	Zone    string
+	Region  string
+	Name    string
+}
+
+// String returns the disk as a resource path, or its name when its location is unknown
+func (d persistentDisk) String() string {
+	switch {
+	case d.Zone != "":
+		return fmt.Sprintf("projects/%s/zones/%s/disks/%s", d.Project, d.Zone, d.Name)
+	case d.Region != "":
+		return fmt.Sprintf("projects/%s/regions/%s/disks/%s", d.Project, d.Region, d.Name)
+	}
+	return d.Name
+}
+
+// persistentVolumeDisk returns the Compute Engine disk backing a persistent volume: from
+// the CSI volume handle (projects/p/zones/z/disks/name or projects/p/regions/r/disks/name)
+// or the legacy in-tree pdName
+func persistentVolumeDisk(volume *corev1.PersistentVolume) (persistentDisk, bool) {
+	if csi := volume.Spec.CSI; csi != nil && csi.Driver == gcePDCSIDriver && csi.VolumeHandle != "" {
+		return parseDiskHandle(csi.VolumeHandle)
+	}
+	if pd := volume.Spec.GCEPersistentDisk; pd != nil && pd.PDName != "" {
+		return persistentDisk{Name: pd.PDName}, true
+	}
+	return persistentDisk{}, false
+}
+
+// parseDiskHandle parses a persistent disk CSI volume handle
+func parseDiskHandle(handle string) (persistentDisk, bool) {
+	parts := strings.Split(handle, "/")
+	if len(parts) != 6 || parts[0] != "projects" || parts[4] != "disks" ||
+		parts[1] == "" || parts[3] == "" || parts[5] == "" {
+		return persistentDisk{}, false
+	}
+
+	disk := persistentDisk{Project: parts[1], Name: parts[5]}
+	switch parts[2] {
+	case "zones":
+		disk.Zone = parts[3]
+	case "regions":
+		disk.Region = parts[3]
+	default:
+		return persistentDisk{}, false
+	}
+	return disk, true
+}
+
+// matchesDisk reports whether a Disk or RegionDisk managed resource is the disk of a
+// persistent volume. A zonal disk is only matched by a Disk in its zone and a regional
+// disk by a RegionDisk in its region, and the project is compared when it is known.
+func (h *CrossplaneHandler) matchesDisk(resource *unstructured.Unstructured, disk persistentDisk) bool {
+	if !matchesExternalName(resource, disk.Name) {
+		return false
+	}
+
+	switch {
+	case disk.Zone != "":
+		if resource.GetKind() != "Disk" {
+			return false
+		}
+		if zone, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "zone"); zone != "" && zone != disk.Zone {
+			return false
+		}
+	case disk.Region != "":
+		if resource.GetKind() != "RegionDisk" {
+			return false
+		}
+		if region, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "region"); region != "" && region != disk.Region {
+			return false
+		}
+	}
+
+	if disk.Project == "" {
+		return true
+	}
+	project, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "project")
+	if project == "" {
+		project = h.projectID
+	}
+	return project == "" || project == disk.Project
+}
+
+// findPersistentDisks returns evidence for the compute disks backing the persistent volume
+// claims of a namespace
+func (h *CrossplaneHandler) findPersistentDisks(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	nsCtx *NamespaceContext,
+) []Evidence {
+	volumes := make(map[string]*corev1.PersistentVolume, len(nsCtx.PersistentVolumes))
+	for i := range nsCtx.PersistentVolumes {
+		volumes[nsCtx.PersistentVolumes[i].Name] = &nsCtx.PersistentVolumes[i]
+	}
+
+	var evidence []Evidence
+	for _, claim := range nsCtx.PersistentVolumeClaims {
+		volume, ok := volumes[claim.Spec.VolumeName]
+		if !ok {
+			continue
+		}
+		disk, ok := persistentVolumeDisk(volume)
+		if !ok {
+			continue
+		}
+
+		for _, t := range resourceTypes {
+			if t.GVR.Group != "compute.gcp.upbound.io" || !diskKinds[t.Kind] {
+				continue
+			}
+			for _, resource := range h.listNamespaced(ctx, t.GVR, "") {
+				resource := resource
+				if !h.matchesDisk(&resource, disk) {
+					continue
+				}
+				evidence = append(evidence, Evidence{
+					Resource:   resource,
+					Confidence: persistentDiskConfidence,
+					Reason: fmt.Sprintf("PersistentVolumeClaim %s/%s is bound to PersistentVolume %s on disk %s",
+						nsCtx.Namespace, claim.Name, volume.Name, disk),
+				})
+			}
+		}
+	}
+	return evidence
+}
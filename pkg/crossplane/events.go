@@ -0,0 +1,76 @@
+package crossplane
+
+import (
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// resourceEventBufferSize is the number of managed resource events a subscriber can
+// fall behind before further events are dropped
+const resourceEventBufferSize = 1024
+
+// Subscribe returns a channel that receives managed resources when they are created or
+// deleted, and when their spec, IP addresses, labels, owner references or external name
+// change. Status and other annotation updates are not sent. Labels written by
+// ApplyLabelsToResource are sent too, and settle after one more reconcile that finds
+// nothing left to change. Events are dropped when the subscriber falls behind, so
+// subscribers should keep a periodic resync.
+//
+// The channel is meant to be used as a controller-runtime source.Channel.
+func (h *CrossplaneHandler) Subscribe() <-chan event.GenericEvent {
+	events := make(chan event.GenericEvent, resourceEventBufferSize)
+	send := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		resource, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+
+		select {
+		case events <- event.GenericEvent{Object: resource}:
+		default:
+			log.V(1).Info("Dropping managed resource event, subscriber is behind",
+				"resource", resource.GetKind()+"/"+resource.GetName())
+		}
+	}
+
+	h.cache.addEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: send,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if h.resourceChanged(oldObj, newObj) {
+				send(newObj)
+			}
+		},
+		DeleteFunc: send,
+	})
+	return events
+}
+
+// resourceChanged reports whether an update can change which namespace a managed
+// resource belongs to: its spec, IP addresses, labels such as the claim labels, owner
+// references or external name changed
+func (h *CrossplaneHandler) resourceChanged(oldObj, newObj interface{}) bool {
+	oldResource, ok := oldObj.(*unstructured.Unstructured)
+	if !ok {
+		return true
+	}
+	newResource, ok := newObj.(*unstructured.Unstructured)
+	if !ok {
+		return true
+	}
+
+	if oldResource.GetGeneration() != newResource.GetGeneration() {
+		return true
+	}
+	if !reflect.DeepEqual(oldResource.GetLabels(), newResource.GetLabels()) ||
+		!reflect.DeepEqual(oldResource.GetOwnerReferences(), newResource.GetOwnerReferences()) ||
+		oldResource.GetAnnotations()[ExternalNameAnnotation] != newResource.GetAnnotations()[ExternalNameAnnotation] {
+		return true
+	}
+	return !reflect.DeepEqual(h.extractIPAddresses(oldResource), h.extractIPAddresses(newResource))
+}
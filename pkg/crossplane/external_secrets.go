@@ -0,0 +1,188 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// secretManagerGroup is the API group of the Secret Manager managed resources
+	secretManagerGroup = "secretmanager.gcp.upbound.io"
+
+	// externalSecretsGroup is the API group of the External Secrets Operator
+	externalSecretsGroup = "external-secrets.io"
+
+	// externalSecretConfidence is the confidence of a match backed by an ExternalSecret
+	// reading a Secret Manager secret
+	externalSecretConfidence = 0.9
+)
+
+// remoteSecret is a Secret Manager secret read by an ExternalSecret
+type remoteSecret struct {
+	// Project is the project of the secret, when the key or the store names one
+	Project string
+	// Name is the secret ID
+	Name string
+	// Source describes the ExternalSecret and store reading the secret
+	Source string
+}
+
+// parseSecretManagerKey parses a remote key, either a secret ID or a resource path such as
+// projects/p/secrets/name/versions/latest, into its project and secret ID
+func parseSecretManagerKey(key string) (string, string) {
+	parts := strings.Split(key, "/")
+	if len(parts) >= 4 && parts[0] == "projects" && parts[2] == "secrets" {
+		return parts[1], parts[3]
+	}
+	return "", key
+}
+
+// externalSecretKeys returns the remote keys an ExternalSecret reads, through spec.data
+// and spec.dataFrom extracts
+func externalSecretKeys(externalSecret *unstructured.Unstructured) []string {
+	var keys []string
+
+	data, _, _ := unstructured.NestedSlice(externalSecret.Object, "spec", "data")
+	for _, item := range data {
+		if itemMap, ok := item.(map[string]interface{}); ok {
+			if key, _, _ := unstructured.NestedString(itemMap, "remoteRef", "key"); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	dataFrom, _, _ := unstructured.NestedSlice(externalSecret.Object, "spec", "dataFrom")
+	for _, item := range dataFrom {
+		if itemMap, ok := item.(map[string]interface{}); ok {
+			if key, _, _ := unstructured.NestedString(itemMap, "extract", "key"); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return dedupe(keys)
+}
+
+// listExternalSecretsObjects lists objects of an External Secrets Operator kind at its
+// preferred version. Nothing is returned when the operator is not installed.
+func (h *CrossplaneHandler) listExternalSecretsObjects(ctx context.Context, kind, namespace string) []unstructured.Unstructured {
+	mapping, err := h.mapper.RESTMapping(schema.GroupKind{Group: externalSecretsGroup, Kind: kind})
+	if err != nil {
+		if !meta.IsNoMatchError(err) {
+			log.Error(err, "Failed to resolve External Secrets kind", "kind", kind)
+		}
+		return nil
+	}
+
+	list, err := h.dynamicClient.Resource(mapping.Resource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Error(err, "Failed to list External Secrets objects", "kind", kind, "namespace", namespace)
+		return nil
+	}
+	return list.Items
+}
+
+// remoteSecretsForNamespace returns the Secret Manager secrets read by the ExternalSecrets
+// of a namespace. The project comes from the key when it is a resource path, and otherwise
+// from the gcpsm provider of the referenced SecretStore or ClusterSecretStore.
+func (h *CrossplaneHandler) remoteSecretsForNamespace(ctx context.Context, namespace string) []remoteSecret {
+	externalSecrets := h.listExternalSecretsObjects(ctx, "ExternalSecret", namespace)
+	if len(externalSecrets) == 0 {
+		return nil
+	}
+
+	// Only stores backed by Secret Manager are recorded, so the keys of Vault, AWS and other
+	// stores are never matched against Secret Manager secrets
+	storeProjects := make(map[string]string)
+	for _, kind := range []string{"SecretStore", "ClusterSecretStore"} {
+		storeNamespace := namespace
+		if kind == "ClusterSecretStore" {
+			storeNamespace = ""
+		}
+		for _, store := range h.listExternalSecretsObjects(ctx, kind, storeNamespace) {
+			gcpsm, ok, _ := unstructured.NestedMap(store.Object, "spec", "provider", "gcpsm")
+			if !ok {
+				continue
+			}
+			project, _ := gcpsm["projectID"].(string)
+			storeProjects[kind+"/"+store.GetName()] = project
+		}
+	}
+
+	var secrets []remoteSecret
+	for i := range externalSecrets {
+		externalSecret := &externalSecrets[i]
+		storeName, _, _ := unstructured.NestedString(externalSecret.Object, "spec", "secretStoreRef", "name")
+		storeKind, _, _ := unstructured.NestedString(externalSecret.Object, "spec", "secretStoreRef", "kind")
+		if storeKind == "" {
+			storeKind = "SecretStore"
+		}
+		storeProject, ok := storeProjects[storeKind+"/"+storeName]
+		if !ok {
+			// The store is missing or does not use Secret Manager
+			continue
+		}
+
+		for _, key := range externalSecretKeys(externalSecret) {
+			project, name := parseSecretManagerKey(key)
+			if project == "" {
+				project = storeProject
+			}
+			secrets = append(secrets, remoteSecret{
+				Project: project,
+				Name:    name,
+				Source:  fmt.Sprintf("ExternalSecret %s/%s reads %s through %s %s", namespace, externalSecret.GetName(), key, storeKind, storeName),
+			})
+		}
+	}
+	return secrets
+}
+
+// findExternalSecrets returns evidence for the Secret Manager secrets read by the
+// ExternalSecrets of a namespace, matched by the external name of the Secret managed resource
+func (h *CrossplaneHandler) findExternalSecrets(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	nsCtx *NamespaceContext,
+) []Evidence {
+	secrets := h.remoteSecretsForNamespace(ctx, nsCtx.Namespace)
+	if len(secrets) == 0 {
+		return nil
+	}
+
+	var evidence []Evidence
+	for _, t := range resourceTypes {
+		if t.GVR.Group != secretManagerGroup || t.Kind != "Secret" {
+			continue
+		}
+		for _, resource := range h.listNamespaced(ctx, t.GVR, "") {
+			resource := resource
+			project, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "project")
+			if project == "" {
+				project = h.projectID
+			}
+
+			for _, secret := range secrets {
+				if !matchesExternalName(&resource, secret.Name) {
+					continue
+				}
+				if secret.Project != "" && project != "" && secret.Project != project {
+					continue
+				}
+				evidence = append(evidence, Evidence{
+					Resource:   resource,
+					Confidence: externalSecretConfidence,
+					Reason:     secret.Source,
+				})
+				break
+			}
+		}
+	}
+	return evidence
+}
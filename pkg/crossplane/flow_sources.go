@@ -0,0 +1,261 @@
+package crossplane
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// flowFilePollInterval is how often a flow log file is checked for new records
+	flowFilePollInterval = 10 * time.Second
+
+	// maxFlowPushSize is the largest flow push request body accepted
+	maxFlowPushSize = 16 << 20
+
+	// flowFileBatchSize is how many flow records read from a file are ingested at once
+	flowFileBatchSize = 1000
+)
+
+// FlowFileSource tails a JSON-lines flow log file and ingests its records. A file that
+// shrinks is taken as rotated and read again from the start.
+type FlowFileSource struct {
+	handler *CrossplaneHandler
+	path    string
+	offset  int64
+}
+
+// NewFlowFileSource creates a source ingesting the flow log file at a path. Add it to the
+// manager to start it.
+func NewFlowFileSource(handler *CrossplaneHandler, path string) *FlowFileSource {
+	return &FlowFileSource{handler: handler, path: path}
+}
+
+// Start polls the file for new records until the context is cancelled
+func (s *FlowFileSource) Start(ctx context.Context) error {
+	log.Info("Ingesting flow logs from file", "path", s.path)
+	ticker := time.NewTicker(flowFilePollInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.poll(); err != nil {
+			log.Error(err, "Failed to read flow log file", "path", s.path)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll ingests the complete lines appended since the last poll. The file is streamed, one
+// line at a time, and the offset only moves past the lines read in full.
+func (s *FlowFileSource) poll() error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < s.offset {
+		s.offset = 0
+	}
+	if info.Size() == s.offset {
+		return nil
+	}
+	if _, err := file.Seek(s.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Read no further than the size seen above, so a writer appending to the file cannot
+	// keep a poll going
+	reader := bufio.NewReader(io.LimitReader(file, info.Size()-s.offset))
+
+	var records []FlowRecord
+	accepted, total, invalid, oversized := 0, 0, 0, 0
+	ingest := func() {
+		accepted += s.handler.IngestFlows(records)
+		total += len(records)
+		records = records[:0]
+	}
+
+	for {
+		line, size, err := readFlowLine(reader)
+		if errors.Is(err, errFlowLineTooLong) {
+			s.offset += size
+			oversized++
+			continue
+		}
+		if err != nil {
+			ingest()
+			if oversized > 0 {
+				log.Info("Skipped flow log lines longer than the limit",
+					"path", s.path, "lines", oversized, "limit", maxFlowLineSize)
+			}
+			log.V(1).Info("Ingested flow records from file",
+				"path", s.path, "accepted", accepted, "records", total, "invalid", invalid)
+			if err == io.EOF {
+				// Leave a partially written last line for the next poll
+				return nil
+			}
+			return err
+		}
+		s.offset += size
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record FlowRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			invalid++
+			continue
+		}
+		records = append(records, record)
+		if len(records) == flowFileBatchSize {
+			ingest()
+		}
+	}
+}
+
+// errFlowLineTooLong is returned for a flow log line longer than maxFlowLineSize
+var errFlowLineTooLong = errors.New("flow log line too long")
+
+// readFlowLine reads a complete line and the number of bytes it takes up in the file. A
+// line longer than maxFlowLineSize is read past and errFlowLineTooLong returned with its
+// size. A last line without a newline is not complete and io.EOF is returned.
+func readFlowLine(reader *bufio.Reader) ([]byte, int64, error) {
+	var line []byte
+	var size int64
+	tooLong := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		size += int64(len(chunk))
+		if !tooLong {
+			if len(line)+len(chunk) > maxFlowLineSize+1 {
+				tooLong = true
+				line = nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		switch {
+		case err == nil && tooLong:
+			return nil, size, errFlowLineTooLong
+		case err == nil:
+			return line, size, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		default:
+			return nil, 0, err
+		}
+	}
+}
+
+// FlowHTTPSource serves an HTTP endpoint that flow exporters push JSON-lines records to
+// with POST /flows. Pushes must carry the token as a bearer token when one is set.
+type FlowHTTPSource struct {
+	handler *CrossplaneHandler
+	address string
+	token   string
+}
+
+// NewFlowHTTPSource creates a source listening on an address such as 127.0.0.1:9095. Add
+// it to the manager to start it. Pushed records feed attribution, so an address reachable
+// from outside the pod is rejected unless a token is set.
+func NewFlowHTTPSource(handler *CrossplaneHandler, address, token string) (*FlowHTTPSource, error) {
+	if token == "" && !isLoopbackAddress(address) {
+		return nil, fmt.Errorf("flow endpoint address %q is not a loopback address and no token is set", address)
+	}
+	return &FlowHTTPSource{handler: handler, address: address, token: token}, nil
+}
+
+// isLoopbackAddress reports whether a listen address only accepts local connections. An
+// empty host listens on every interface.
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Start serves the endpoint until the context is cancelled
+func (s *FlowHTTPSource) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flows", s.serveFlows)
+	server := &http.Server{Addr: s.address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error(err, "Failed to shut down flow endpoint")
+		}
+	}()
+
+	log.Info("Accepting flow records", "address", s.address)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve flow endpoint: %v", err)
+	}
+	return nil
+}
+
+// serveFlows ingests the records of a push and reports how many were accepted
+func (s *FlowHTTPSource) serveFlows(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	records, invalid, err := ReadFlows(http.MaxBytesReader(w, r.Body, maxFlowPushSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read flow records: %v", err), http.StatusBadRequest)
+		return
+	}
+	accepted := s.handler.IngestFlows(records)
+	log.V(1).Info("Ingested pushed flow records", "accepted", accepted, "records", len(records), "invalid", invalid)
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "{\"accepted\":%d,\"rejected\":%d}\n", accepted, len(records)-accepted+invalid)
+}
+
+// authorized reports whether a push carries the configured bearer token
+func (s *FlowHTTPSource) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(header[len(prefix):]), []byte(s.token)) == 1
+}
@@ -0,0 +1,280 @@
+package crossplane
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// flowHalfLife is the age at which the bytes of a flow count half as much
+	flowHalfLife = 15 * time.Minute
+
+	// flowRetention is how long flows are kept after they were last seen
+	flowRetention = 6 * time.Hour
+
+	// flowScaleBytes is the recency-weighted traffic volume at which flow confidence has
+	// covered about two thirds of the way from its minimum to its maximum
+	flowScaleBytes = 1 << 20
+
+	// Bounds of the confidence of flow evidence. Observed traffic is stronger evidence
+	// than a pod IP merely matching a resource IP, so even a trickle scores above the
+	// metadata matches.
+	flowMinConfidence = 0.6
+	flowMaxConfidence = 0.98
+
+	// maxFlowLineSize is the longest flow record line accepted
+	maxFlowLineSize = 64 * 1024
+)
+
+// FlowRecord is a connection record from a flow log, such as a VPC flow log or a Hubble
+// flow export, flattened to one JSON object per line
+type FlowRecord struct {
+	// SourceIP is the address the connection came from
+	SourceIP string `json:"src_ip"`
+	// DestinationIP is the address the connection went to
+	DestinationIP string `json:"dst_ip"`
+	// DestinationPort is the port the connection went to
+	DestinationPort int `json:"dst_port,omitempty"`
+	// Bytes is the number of bytes exchanged
+	Bytes int64 `json:"bytes"`
+	// Timestamp is when the connection was observed; records without one are taken as current
+	Timestamp time.Time `json:"timestamp,omitempty"`
+}
+
+// flowKey identifies the traffic from one source to one destination. The namespace is the
+// one holding the source IP when the traffic was observed, if known.
+type flowKey struct {
+	namespace     string
+	sourceIP      string
+	destinationIP string
+}
+
+// flowStats aggregates the traffic of a flow key
+type flowStats struct {
+	// score is the recency-weighted byte count as of asOf
+	score float64
+	asOf  time.Time
+	// bytes is the total byte count
+	bytes    int64
+	lastSeen time.Time
+	ports    map[int]bool
+}
+
+// decayed returns the recency-weighted byte count at a time
+func (s *flowStats) decayed(at time.Time) float64 {
+	return s.score * math.Pow(0.5, float64(at.Sub(s.asOf))/float64(flowHalfLife))
+}
+
+// flowStore aggregates ingested flow records
+type flowStore struct {
+	mu    sync.Mutex
+	flows map[flowKey]*flowStats
+}
+
+// newFlowStore creates an empty flow store
+func newFlowStore() *flowStore {
+	return &flowStore{flows: make(map[flowKey]*flowStats)}
+}
+
+// add aggregates a record under a key
+func (s *flowStore) add(key flowKey, record FlowRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.flows[key]
+	if !ok {
+		stats = &flowStats{asOf: record.Timestamp, ports: make(map[int]bool)}
+		s.flows[key] = stats
+	}
+
+	// Scores are kept as of the latest record, and older records are decayed to it
+	weight := float64(record.Bytes)
+	if record.Timestamp.After(stats.asOf) {
+		stats.score = stats.decayed(record.Timestamp)
+		stats.asOf = record.Timestamp
+	} else {
+		weight *= math.Pow(0.5, float64(stats.asOf.Sub(record.Timestamp))/float64(flowHalfLife))
+	}
+	stats.score += weight
+	stats.bytes += record.Bytes
+	if record.Timestamp.After(stats.lastSeen) {
+		stats.lastSeen = record.Timestamp
+	}
+	if record.DestinationPort > 0 {
+		stats.ports[record.DestinationPort] = true
+	}
+}
+
+// prune drops the flows last seen before the retention period
+func (s *flowStore) prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, stats := range s.flows {
+		if now.Sub(stats.lastSeen) > flowRetention {
+			delete(s.flows, key)
+		}
+	}
+}
+
+// destinationTraffic is the traffic from a namespace to one destination IP
+type destinationTraffic struct {
+	destinationIP string
+	score         float64
+	bytes         int64
+	lastSeen      time.Time
+	ports         []int
+}
+
+// trafficFrom returns the traffic from a namespace, by destination IP. Flows whose source
+// namespace is unknown count when their source is one of the namespace's pod IPs.
+func (s *flowStore) trafficFrom(namespace string, podIPs map[string]bool, now time.Time) []destinationTraffic {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byDestination := make(map[string]*destinationTraffic)
+	ports := make(map[string]map[int]bool)
+	for key, stats := range s.flows {
+		if key.namespace != namespace && (key.namespace != "" || !podIPs[key.sourceIP]) {
+			continue
+		}
+		traffic, ok := byDestination[key.destinationIP]
+		if !ok {
+			traffic = &destinationTraffic{destinationIP: key.destinationIP}
+			byDestination[key.destinationIP] = traffic
+			ports[key.destinationIP] = make(map[int]bool)
+		}
+		traffic.score += stats.decayed(now)
+		traffic.bytes += stats.bytes
+		if stats.lastSeen.After(traffic.lastSeen) {
+			traffic.lastSeen = stats.lastSeen
+		}
+		for port := range stats.ports {
+			ports[key.destinationIP][port] = true
+		}
+	}
+
+	traffic := make([]destinationTraffic, 0, len(byDestination))
+	for ip, t := range byDestination {
+		for port := range ports[ip] {
+			t.ports = append(t.ports, port)
+		}
+		sort.Ints(t.ports)
+		traffic = append(traffic, *t)
+	}
+	sort.Slice(traffic, func(i, j int) bool { return traffic[i].destinationIP < traffic[j].destinationIP })
+	return traffic
+}
+
+// flowConfidence turns recency-weighted traffic volume into a confidence between the flow
+// confidence bounds, rising quickly for the first megabytes and saturating after that
+func flowConfidence(score float64) float64 {
+	return flowMinConfidence + (flowMaxConfidence-flowMinConfidence)*(1-math.Exp(-score/flowScaleBytes))
+}
+
+// IngestFlows adds flow records as network evidence and returns how many were accepted.
+// Records need valid source and destination IPs and a byte count; records in the future
+// or older than the retention period are ignored. The namespace of each record is the
+// one whose pod held the source IP at the record's time.
+func (h *CrossplaneHandler) IngestFlows(records []FlowRecord) int {
+	now := time.Now()
+	accepted := 0
+	for _, record := range records {
+		src, ok := parseIP(record.SourceIP)
+		if !ok {
+			continue
+		}
+		dst, ok := parseIP(record.DestinationIP)
+		if !ok || record.Bytes <= 0 {
+			continue
+		}
+		if record.Timestamp.IsZero() {
+			record.Timestamp = now
+		}
+		if record.Timestamp.After(now) || now.Sub(record.Timestamp) > flowRetention {
+			continue
+		}
+
+		key := flowKey{sourceIP: src.String(), destinationIP: dst.String()}
+		key.namespace, _ = h.leases.namespaceAt(key.sourceIP, record.Timestamp)
+		h.flows.add(key, record)
+		accepted++
+	}
+	h.flows.prune(now)
+	return accepted
+}
+
+// ReadFlows parses JSON-lines flow records, skipping blank and malformed lines, and
+// returns the records with the number of lines skipped as malformed
+func ReadFlows(r io.Reader) ([]FlowRecord, int, error) {
+	var records []FlowRecord
+	invalid := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxFlowLineSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var record FlowRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			invalid++
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, invalid, scanner.Err()
+}
+
+// findFlows returns evidence for the resources the pods of a namespace sent traffic to
+// according to the ingested flow logs, weighted by volume and recency
+func (h *CrossplaneHandler) findFlows(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	nsCtx *NamespaceContext,
+) []Evidence {
+	podIPs := make(map[string]bool)
+	for _, ip := range canonicalIPs(nsCtx.PodIPs()) {
+		podIPs[ip] = true
+	}
+
+	now := time.Now()
+	var evidence []Evidence
+	for _, traffic := range h.flows.trafficFrom(nsCtx.Namespace, podIPs, now) {
+		holders := h.resourcesForIP(ctx, resourceTypes, traffic.destinationIP)
+		if len(holders) == 0 {
+			continue
+		}
+
+		confidence := flowConfidence(traffic.score)
+		reason := fmt.Sprintf("Flow logs show %d bytes from the namespace to %s%s, last seen %s ago",
+			traffic.bytes, traffic.destinationIP, formatPorts(traffic.ports),
+			now.Sub(traffic.lastSeen).Round(time.Second))
+		for _, holder := range holders {
+			evidence = append(evidence, Evidence{
+				Resource:   holder.resource,
+				Confidence: confidence,
+				Reason:     reason,
+			})
+		}
+	}
+	return evidence
+}
+
+// formatPorts formats destination ports for a match reason
+func formatPorts(ports []int) string {
+	switch len(ports) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf(" port %d", ports[0])
+	default:
+		return fmt.Sprintf(" ports %v", ports)
+	}
+}
@@ -0,0 +1,247 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// WorkloadIdentityAnnotation binds a Kubernetes ServiceAccount to a GCP service account
+	WorkloadIdentityAnnotation = "iam.gke.io/gcp-service-account"
+
+	// ExternalNameAnnotation holds the name of the external resource of a managed resource
+	ExternalNameAnnotation = "crossplane.io/external-name"
+
+	// serviceAccountIndex indexes GCP service accounts by email, and IAM members by the
+	// service account emails they grant to
+	serviceAccountIndex = "serviceAccount"
+
+	// Confidences of the links in a Workload Identity chain
+	workloadIdentityServiceAccountConfidence = 0.85
+	workloadIdentityMemberConfidence         = 0.85
+	workloadIdentityTargetConfidence         = 0.8
+)
+
+// iamMemberFields are forProvider fields of IAM member kinds that never name the
+// resource access is granted on
+var iamMemberFields = map[string]bool{
+	"member":    true,
+	"members":   true,
+	"role":      true,
+	"project":   true,
+	"condition": true,
+	"etag":      true,
+}
+
+// serviceAccountEmail returns the email of a GCP service account managed resource, from its
+// status or, before it is observed, from its external name and project
+func (h *CrossplaneHandler) serviceAccountEmail(resource *unstructured.Unstructured) (string, bool) {
+	if resource.GetKind() != "ServiceAccount" {
+		return "", false
+	}
+	if email, _, _ := unstructured.NestedString(resource.Object, "status", "atProvider", "email"); email != "" {
+		return strings.ToLower(email), true
+	}
+
+	accountID := resource.GetAnnotations()[ExternalNameAnnotation]
+	if accountID == "" {
+		return "", false
+	}
+	project, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "project")
+	if project == "" {
+		project = h.projectID
+	}
+	if project == "" {
+		return "", false
+	}
+	return strings.ToLower(fmt.Sprintf("%s@%s.iam.gserviceaccount.com", accountID, project)), true
+}
+
+// iamMemberEmails returns the GCP service account emails an IAM member or binding managed
+// resource grants to
+func iamMemberEmails(resource *unstructured.Unstructured) []string {
+	if !strings.HasSuffix(resource.GetKind(), "IAMMember") && !strings.HasSuffix(resource.GetKind(), "IAMBinding") {
+		return nil
+	}
+
+	var members []string
+	if member, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "member"); member != "" {
+		members = append(members, member)
+	}
+	list, _, _ := unstructured.NestedStringSlice(resource.Object, "spec", "forProvider", "members")
+	members = append(members, list...)
+
+	var emails []string
+	for _, member := range members {
+		if email, ok := strings.CutPrefix(member, "serviceAccount:"); ok {
+			emails = append(emails, strings.ToLower(email))
+		}
+	}
+	return emails
+}
+
+// serviceAccountIndexKeys returns the service account index keys of a resource
+func (h *CrossplaneHandler) serviceAccountIndexKeys(resource *unstructured.Unstructured) []string {
+	if email, ok := h.serviceAccountEmail(resource); ok {
+		return []string{email}
+	}
+	return dedupe(iamMemberEmails(resource))
+}
+
+// findWorkloadIdentityAccess returns evidence for the resources the workloads of a namespace
+// can access through Workload Identity: the GCP service accounts their Kubernetes service
+// accounts impersonate, the IAM members granting roles to those accounts, and the
+// resources the roles are granted on
+func (h *CrossplaneHandler) findWorkloadIdentityAccess(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	nsCtx *NamespaceContext,
+) []Evidence {
+	serviceAccounts := make(map[string]*corev1.ServiceAccount, len(nsCtx.ServiceAccounts))
+	for i := range nsCtx.ServiceAccounts {
+		serviceAccounts[nsCtx.ServiceAccounts[i].Name] = &nsCtx.ServiceAccounts[i]
+	}
+
+	// Map each GCP service account to the first pod and Kubernetes service account using it
+	chains := make(map[string]string)
+	for _, pod := range nsCtx.Pods {
+		ksaName := pod.Spec.ServiceAccountName
+		if ksaName == "" {
+			ksaName = "default"
+		}
+		ksa, ok := serviceAccounts[ksaName]
+		if !ok {
+			continue
+		}
+		email := strings.ToLower(ksa.Annotations[WorkloadIdentityAnnotation])
+		if email == "" {
+			continue
+		}
+		if _, ok := chains[email]; !ok {
+			chains[email] = fmt.Sprintf("Pod %s runs as ServiceAccount %s/%s bound to %s", pod.Name, nsCtx.Namespace, ksaName, email)
+		}
+	}
+	if len(chains) == 0 {
+		return nil
+	}
+
+	emails := make([]string, 0, len(chains))
+	for email := range chains {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	var evidence []Evidence
+	for _, email := range emails {
+		chain := chains[email]
+		for _, resource := range h.serviceAccountResources(ctx, resourceTypes, email) {
+			resource := resource
+			if _, ok := h.serviceAccountEmail(&resource); ok {
+				evidence = append(evidence, Evidence{
+					Resource:   resource,
+					Confidence: workloadIdentityServiceAccountConfidence,
+					Reason:     chain,
+				})
+				continue
+			}
+
+			role, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "role")
+			memberChain := fmt.Sprintf("%s, granted %s by %s %s", chain, role, resource.GetKind(), resource.GetName())
+			evidence = append(evidence, Evidence{
+				Resource:   resource,
+				Confidence: workloadIdentityMemberConfidence,
+				Reason:     memberChain,
+			})
+
+			for _, target := range h.iamTargets(ctx, resourceTypes, &resource) {
+				evidence = append(evidence, Evidence{
+					Resource:   target,
+					Confidence: workloadIdentityTargetConfidence,
+					Reason:     fmt.Sprintf("%s on %s %s", memberChain, target.GetKind(), target.GetName()),
+				})
+			}
+		}
+	}
+	return evidence
+}
+
+// serviceAccountResources returns the GCP service accounts with an email and the IAM
+// members granting to it, from the cache's service account index for the types that are
+// cached and scanned for the others
+func (h *CrossplaneHandler) serviceAccountResources(ctx context.Context, resourceTypes []ManagedResourceType, email string) []unstructured.Unstructured {
+	cached, uncached := h.cache.partition(resourceTypes)
+	resources := h.cache.byIndex(cached, serviceAccountIndex, email)
+	for _, t := range uncached {
+		for _, resource := range h.listNamespaced(ctx, t.GVR, "") {
+			for _, key := range h.serviceAccountIndexKeys(&resource) {
+				if key == email {
+					resources = append(resources, resource)
+					break
+				}
+			}
+		}
+	}
+	return resources
+}
+
+// iamTargets returns the resources an IAM member grants access on. Member kinds name their
+// target in a forProvider field named after the target kind, either as a reference to the
+// managed resource (bucketRef, cryptoKeyIdRef) or as its external name (bucket, topic).
+func (h *CrossplaneHandler) iamTargets(ctx context.Context, resourceTypes []ManagedResourceType, member *unstructured.Unstructured) []unstructured.Unstructured {
+	forProvider, _, _ := unstructured.NestedMap(member.Object, "spec", "forProvider")
+	group := member.GroupVersionKind().Group
+
+	fields := make([]string, 0, len(forProvider))
+	for field := range forProvider {
+		if !iamMemberFields[field] {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var targets []unstructured.Unstructured
+	for _, field := range fields {
+		value := forProvider[field]
+
+		var refName, externalName string
+		kindName := field
+		if ref, ok := value.(map[string]interface{}); ok && strings.HasSuffix(field, "Ref") {
+			refName, _ = ref["name"].(string)
+			kindName = strings.TrimSuffix(field, "Ref")
+		} else if s, ok := value.(string); ok {
+			externalName = s
+		}
+		if refName == "" && externalName == "" {
+			continue
+		}
+		kindName = strings.TrimSuffix(strings.TrimSuffix(kindName, "Id"), "Name")
+
+		for _, t := range resourceTypes {
+			if t.GVR.Group != group || !strings.EqualFold(t.Kind, kindName) {
+				continue
+			}
+			for _, resource := range h.listNamespaced(ctx, t.GVR, "") {
+				if (refName != "" && resource.GetName() == refName) ||
+					(externalName != "" && matchesExternalName(&resource, externalName)) {
+					targets = append(targets, resource)
+				}
+			}
+		}
+	}
+	return targets
+}
+
+// matchesExternalName reports whether a value names a managed resource, either by its
+// external name or as a resource path ending in it (projects/p/topics/name)
+func matchesExternalName(resource *unstructured.Unstructured, value string) bool {
+	externalName := resource.GetAnnotations()[ExternalNameAnnotation]
+	if externalName == "" {
+		externalName = resource.GetName()
+	}
+	return value == externalName || strings.HasSuffix(value, "/"+externalName)
+}
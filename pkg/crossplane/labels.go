@@ -0,0 +1,294 @@
+package crossplane
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ErrUnlabellable is returned when GCP labels are requested for a kind that cannot carry them
+var ErrUnlabellable = errors.New("resource kind does not support GCP labels")
+
+// LabelWriteMode controls where ApplyLabelsToResource writes labels
+type LabelWriteMode string
+
+const (
+	// LabelWriteModeMetadata writes labels to the Kubernetes metadata of the managed resource only
+	LabelWriteModeMetadata LabelWriteMode = "Metadata"
+	// LabelWriteModeForProvider writes labels to spec.forProvider.labels, which the provider
+	// pushes to the GCP resource
+	LabelWriteModeForProvider LabelWriteMode = "ForProvider"
+	// LabelWriteModeBoth writes labels to both the metadata and spec.forProvider.labels
+	LabelWriteModeBoth LabelWriteMode = "Both"
+)
+
+// gcpLabelMaxLength is the maximum length of a GCP label key or value
+const gcpLabelMaxLength = 63
+
+// LabelOptions configures how labels are written to a resource
+type LabelOptions struct {
+	// Mode selects the label destination (default: Metadata)
+	Mode LabelWriteMode
+
+	// Fields locates the GCP labels of each kind (default: DefaultLabelFieldRegistry)
+	Fields *LabelFieldRegistry
+}
+
+// Labellable reports whether labels can be written to resources of the given kind.
+// Only the ForProvider mode can fail, for kinds without a GCP label field.
+func (o LabelOptions) Labellable(gvk schema.GroupVersionKind) bool {
+	return o.Mode != LabelWriteModeForProvider || !o.fields().Lookup(gvk).Unsupported
+}
+
+// fields returns the label field registry to use
+func (o LabelOptions) fields() *LabelFieldRegistry {
+	if o.Fields != nil {
+		return o.Fields
+	}
+	return defaultLabelFields
+}
+
+// writesMetadata reports whether the options write Kubernetes metadata labels
+func (o LabelOptions) writesMetadata() bool {
+	return o.Mode == "" || o.Mode == LabelWriteModeMetadata || o.Mode == LabelWriteModeBoth
+}
+
+// writesProvider reports whether the options write GCP labels through the provider
+func (o LabelOptions) writesProvider() bool {
+	return o.Mode == LabelWriteModeForProvider || o.Mode == LabelWriteModeBoth
+}
+
+// LabelField describes where a managed resource kind keeps its GCP labels
+type LabelField struct {
+	// Path is the location of the labels map relative to spec.forProvider
+	Path []string
+
+	// Unsupported marks kinds that cannot carry GCP labels at all
+	Unsupported bool
+}
+
+// String returns the dotted form of the label field path
+func (f LabelField) String() string {
+	return strings.Join(append([]string{"spec", "forProvider"}, f.Path...), ".")
+}
+
+// defaultLabelField is used for kinds without a registered label field
+var defaultLabelField = LabelField{Path: []string{"labels"}}
+
+// LabelFieldRegistry maps managed resource kinds to the field holding their GCP labels.
+// An entry with an empty version applies to every version of the kind.
+type LabelFieldRegistry struct {
+	fields map[schema.GroupVersionKind]LabelField
+}
+
+// defaultLabelFields is the shared registry of built-in label fields
+var defaultLabelFields = DefaultLabelFieldRegistry()
+
+// NewLabelFieldRegistry creates an empty label field registry
+func NewLabelFieldRegistry() *LabelFieldRegistry {
+	return &LabelFieldRegistry{fields: make(map[schema.GroupVersionKind]LabelField)}
+}
+
+// DefaultLabelFieldRegistry creates a registry with the label fields of the Upbound GCP kinds
+// whose labels do not live in spec.forProvider.labels
+func DefaultLabelFieldRegistry() *LabelFieldRegistry {
+	r := NewLabelFieldRegistry()
+
+	// Kinds that keep their labels somewhere other than forProvider.labels
+	r.Register(schema.GroupVersionKind{Group: "sql.gcp.upbound.io", Kind: "DatabaseInstance"},
+		LabelField{Path: []string{"settings", "userLabels"}})
+	r.Register(schema.GroupVersionKind{Group: "container.gcp.upbound.io", Kind: "Cluster"},
+		LabelField{Path: []string{"resourceLabels"}})
+	r.Register(schema.GroupVersionKind{Group: "container.gcp.upbound.io", Kind: "NodePool"},
+		LabelField{Path: []string{"nodeConfig", "resourceLabels"}})
+
+	// Kinds that have no GCP labels
+	for _, gk := range []schema.GroupKind{
+		{Group: "compute.gcp.upbound.io", Kind: "Firewall"},
+		{Group: "compute.gcp.upbound.io", Kind: "Network"},
+		{Group: "compute.gcp.upbound.io", Kind: "Subnetwork"},
+		{Group: "compute.gcp.upbound.io", Kind: "Router"},
+		{Group: "storage.gcp.upbound.io", Kind: "BucketIAMMember"},
+		{Group: "storage.gcp.upbound.io", Kind: "BucketObject"},
+		{Group: "sql.gcp.upbound.io", Kind: "Database"},
+		{Group: "sql.gcp.upbound.io", Kind: "User"},
+		{Group: "sql.gcp.upbound.io", Kind: "SSLCert"},
+		{Group: "bigtable.gcp.upbound.io", Kind: "Table"},
+		{Group: "spanner.gcp.upbound.io", Kind: "Database"},
+		{Group: "pubsub.gcp.upbound.io", Kind: "TopicIAMMember"},
+		{Group: "pubsub.gcp.upbound.io", Kind: "SubscriptionIAMMember"},
+		{Group: "kms.gcp.upbound.io", Kind: "KeyRing"},
+		{Group: "cloudscheduler.gcp.upbound.io", Kind: "Job"},
+		{Group: "iam.gcp.upbound.io", Kind: "ServiceAccount"},
+		{Group: "iam.gcp.upbound.io", Kind: "ServiceAccountKey"},
+		{Group: "cloudplatform.gcp.upbound.io", Kind: "ServiceAccount"},
+		{Group: "cloudplatform.gcp.upbound.io", Kind: "ServiceAccountIAMMember"},
+		{Group: "cloudplatform.gcp.upbound.io", Kind: "ProjectIAMMember"},
+		{Group: "secretmanager.gcp.upbound.io", Kind: "SecretVersion"},
+		{Group: "secretmanager.gcp.upbound.io", Kind: "SecretIAMMember"},
+	} {
+		r.Register(gk.WithVersion(""), LabelField{Unsupported: true})
+	}
+
+	return r
+}
+
+// Register sets the label field for a kind. Leave the version empty to cover all versions.
+func (r *LabelFieldRegistry) Register(gvk schema.GroupVersionKind, field LabelField) {
+	r.fields[gvk] = field
+}
+
+// Lookup returns the label field for a kind, preferring a version-specific entry
+func (r *LabelFieldRegistry) Lookup(gvk schema.GroupVersionKind) LabelField {
+	if field, ok := r.fields[gvk]; ok {
+		return field
+	}
+	if field, ok := r.fields[gvk.GroupKind().WithVersion("")]; ok {
+		return field
+	}
+	return defaultLabelField
+}
+
+// mergeMetadataLabels merges labels into the Kubernetes metadata labels of a resource
+// and reports whether anything changed
+func mergeMetadataLabels(resource *unstructured.Unstructured, labels map[string]string) bool {
+	currentLabels := resource.GetLabels()
+	if currentLabels == nil {
+		currentLabels = make(map[string]string)
+	}
+
+	changed := false
+	for k, v := range labels {
+		if currentValue, exists := currentLabels[k]; !exists || currentValue != v {
+			currentLabels[k] = v
+			changed = true
+		}
+	}
+
+	if changed {
+		resource.SetLabels(currentLabels)
+	}
+	return changed
+}
+
+// mergeProviderLabels merges labels into the GCP label field of a resource and reports
+// whether anything changed. Keys and values are sanitized to satisfy the GCP label
+// requirements; labels whose key cannot be made valid are skipped.
+func mergeProviderLabels(resource *unstructured.Unstructured, labels map[string]string, field LabelField) (bool, error) {
+	if field.Unsupported || len(field.Path) == 0 {
+		return false, fmt.Errorf("%s: %w", resource.GetKind(), ErrUnlabellable)
+	}
+
+	parent, err := labelFieldParent(resource, field)
+	if err != nil {
+		return false, err
+	}
+
+	labelsKey := field.Path[len(field.Path)-1]
+	currentLabels, _, err := unstructured.NestedStringMap(parent, labelsKey)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %v", field, err)
+	}
+	if currentLabels == nil {
+		currentLabels = make(map[string]string)
+	}
+
+	changed := false
+	for k, v := range labels {
+		key, ok := sanitizeGCPLabelKey(k)
+		if !ok {
+			log.V(1).Info("Skipping label with invalid GCP key",
+				"resource", resource.GetName(),
+				"key", k)
+			continue
+		}
+		value := sanitizeGCPLabelValue(v)
+
+		if currentValue, exists := currentLabels[key]; !exists || currentValue != value {
+			currentLabels[key] = value
+			changed = true
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+	return true, unstructured.SetNestedStringMap(parent, currentLabels, labelsKey)
+}
+
+// labelFieldParent returns the object that holds the labels map of a label field.
+// Single-block provider fields such as settings are lists with one element, so list
+// values are descended through their first element. Only spec.forProvider is created
+// when missing; other intermediate fields must already exist.
+func labelFieldParent(resource *unstructured.Unstructured, field LabelField) (map[string]interface{}, error) {
+	spec, ok := resource.Object["spec"].(map[string]interface{})
+	if !ok {
+		spec = make(map[string]interface{})
+		resource.Object["spec"] = spec
+	}
+	forProvider, ok := spec["forProvider"].(map[string]interface{})
+	if !ok {
+		forProvider = make(map[string]interface{})
+		spec["forProvider"] = forProvider
+	}
+
+	node := forProvider
+	for _, segment := range field.Path[:len(field.Path)-1] {
+		switch value := node[segment].(type) {
+		case map[string]interface{}:
+			node = value
+		case []interface{}:
+			if len(value) == 0 {
+				return nil, fmt.Errorf("%s has no %s: %w", resource.GetKind(), field, ErrUnlabellable)
+			}
+			element, ok := value[0].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid %s: %s is not an object list", field, segment)
+			}
+			node = element
+		case nil:
+			return nil, fmt.Errorf("%s has no %s: %w", resource.GetKind(), field, ErrUnlabellable)
+		default:
+			return nil, fmt.Errorf("invalid %s: %s is not an object", field, segment)
+		}
+	}
+
+	return node, nil
+}
+
+// sanitizeGCPLabelKey converts a label key to a valid GCP label key. GCP keys must start
+// with a lowercase letter and contain only lowercase letters, digits, underscores and dashes.
+func sanitizeGCPLabelKey(key string) (string, bool) {
+	// Drop Kubernetes-style prefixes such as example.com/team
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		key = key[i+1:]
+	}
+
+	key = sanitizeGCPLabelValue(key)
+	if key == "" || key[0] < 'a' || key[0] > 'z' {
+		return "", false
+	}
+	return key, true
+}
+
+// sanitizeGCPLabelValue converts a label value to a valid GCP label value by lowercasing
+// it, replacing unsupported characters with underscores and truncating it
+func sanitizeGCPLabelValue(value string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(value) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-' {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+
+	sanitized := b.String()
+	if len(sanitized) > gcpLabelMaxLength {
+		sanitized = sanitized[:gcpLabelMaxLength]
+	}
+	return sanitized
+}
@@ -0,0 +1,305 @@
+package crossplane
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+// leaseRetention is how long ended pod IP leases are kept. Resource observations are
+// compared against leases that overlap them, and older leases no longer can.
+const leaseRetention = 24 * time.Hour
+
+// IPLease is a period during which a pod held an IP address
+type IPLease struct {
+	// Namespace is the namespace of the pod
+	Namespace string
+	// Pod is the name of the pod
+	Pod string
+	// UID is the UID of the pod, which tells it apart from a later pod with the same name
+	UID string
+	// Start is when the pod started holding the IP
+	Start time.Time
+	// End is when the pod released the IP; zero while it still holds it
+	End time.Time
+}
+
+// overlaps reports whether the lease covers any part of an interval
+func (l IPLease) overlaps(from, to time.Time) bool {
+	return !l.Start.After(to) && (l.End.IsZero() || !l.End.Before(from))
+}
+
+// ipLeaseHistory records which pod held each IP address and when, from pod events, so
+// network evidence is not credited to a namespace that holds a recycled IP
+type ipLeaseHistory struct {
+	mu sync.Mutex
+	// leases are the leases of each IP, oldest first
+	leases map[string][]IPLease
+	// held are the IPs each pod holds an open lease on, by pod UID
+	held map[string]map[string]bool
+	// synced reports whether every existing pod has been observed; nil until it is known
+	synced func() bool
+}
+
+// newIPLeaseHistory creates an empty lease history
+func newIPLeaseHistory() *ipLeaseHistory {
+	return &ipLeaseHistory{
+		leases: make(map[string][]IPLease),
+		held:   make(map[string]map[string]bool),
+	}
+}
+
+// observe records the IPs a pod holds now. The pod's leases on IPs it no longer holds end,
+// and so do the leases of other pods on the IPs it holds. hostNetwork pods hold their
+// node's IP rather than their own, and terminal pods hold none.
+func (l *ipLeaseHistory) observe(pod *corev1.Pod) {
+	ips := make(map[string]bool)
+	if !pod.Spec.HostNetwork && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+		for _, ip := range canonicalIPs(podIPs(pod)) {
+			ips[ip] = true
+		}
+	}
+
+	now := time.Now()
+	uid := string(pod.UID)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.release(uid, ips, now)
+
+	for ip := range ips {
+		if l.held[uid][ip] {
+			continue
+		}
+
+		// Pods seen for the first time held their IP since they started, unless the IP
+		// was still leased to another pod whose release was missed
+		start := now
+		if pod.Status.StartTime != nil && pod.Status.StartTime.Time.Before(now) {
+			start = pod.Status.StartTime.Time
+		}
+		leases := pruneLeases(l.leases[ip], now)
+		for i := range leases {
+			if leases[i].End.IsZero() {
+				leases[i].End = now
+				previous := leases[i].UID
+				delete(l.held[previous], ip)
+				if len(l.held[previous]) == 0 {
+					delete(l.held, previous)
+				}
+				start = now
+			} else if leases[i].End.After(start) {
+				start = leases[i].End
+			}
+		}
+
+		l.leases[ip] = append(leases, IPLease{Namespace: pod.Namespace, Pod: pod.Name, UID: uid, Start: start})
+		if l.held[uid] == nil {
+			l.held[uid] = make(map[string]bool)
+		}
+		l.held[uid][ip] = true
+	}
+}
+
+// forget ends every lease of a deleted pod
+func (l *ipLeaseHistory) forget(pod *corev1.Pod) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.release(string(pod.UID), nil, time.Now())
+}
+
+// release ends a pod's open leases on the IPs it does not hold anymore, and drops the
+// leases of those IPs that ended before the retention period
+func (l *ipLeaseHistory) release(uid string, ips map[string]bool, now time.Time) {
+	for ip := range l.held[uid] {
+		if ips[ip] {
+			continue
+		}
+		delete(l.held[uid], ip)
+
+		leases := l.leases[ip]
+		for i := range leases {
+			if leases[i].UID == uid && leases[i].End.IsZero() {
+				leases[i].End = now
+			}
+		}
+		l.leases[ip] = pruneLeases(leases, now)
+	}
+	if len(l.held[uid]) == 0 {
+		delete(l.held, uid)
+	}
+}
+
+// pruneLeases drops the leases that ended before the retention period
+func pruneLeases(leases []IPLease, now time.Time) []IPLease {
+	cutoff := now.Add(-leaseRetention)
+	kept := leases[:0]
+	for _, lease := range leases {
+		if lease.End.IsZero() || lease.End.After(cutoff) {
+			kept = append(kept, lease)
+		}
+	}
+	return kept
+}
+
+// leasesFor returns the leases recorded for an IP, oldest first
+func (l *ipLeaseHistory) leasesFor(ip string) []IPLease {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]IPLease(nil), l.leases[ip]...)
+}
+
+// namespaceAt returns the namespace whose pod held an IP at a time
+func (l *ipLeaseHistory) namespaceAt(ip string, at time.Time) (string, bool) {
+	for _, lease := range l.leasesFor(ip) {
+		if lease.overlaps(at, at) {
+			return lease.Namespace, true
+		}
+	}
+	return "", false
+}
+
+// namespacesIn returns the namespaces whose pods hold an IP inside a prefix now
+func (l *ipLeaseHistory) namespacesIn(prefix netip.Prefix) map[string]bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	namespaces := make(map[string]bool)
+	for ip, leases := range l.leases {
+		if len(leases) == 0 || !leases[len(leases)-1].End.IsZero() {
+			continue
+		}
+		if addr, ok := parseIP(ip); ok && prefix.Contains(addr) {
+			namespaces[leases[len(leases)-1].Namespace] = true
+		}
+	}
+	return namespaces
+}
+
+// hasSynced reports whether every pod that existed when tracking started has been observed
+func (l *ipLeaseHistory) hasSynced() bool {
+	l.mu.Lock()
+	synced := l.synced
+	l.mu.Unlock()
+	return synced != nil && synced()
+}
+
+// heldBy reports whether a namespace held an IP during any part of an interval. Until
+// the pods have been observed, an IP without recorded leases is accepted; afterwards no
+// pod has held it within the retention period.
+func (l *ipLeaseHistory) heldBy(ip, namespace string, from, to time.Time) bool {
+	leases := l.leasesFor(ip)
+	if len(leases) == 0 {
+		return !l.hasSynced()
+	}
+	for _, lease := range leases {
+		if lease.Namespace == namespace && lease.overlaps(from, to) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPLeases returns the recorded leases of an IP address, oldest first
+func (h *CrossplaneHandler) IPLeases(ip string) []IPLease {
+	addr, ok := parseIP(ip)
+	if !ok {
+		return nil
+	}
+	return h.leases.leasesFor(addr.String())
+}
+
+// PodEventHandler returns an event handler that records pod IP leases. Register it with
+// a pod informer so network evidence is checked against which pod held an IP and when.
+func (h *CrossplaneHandler) PodEventHandler() cache.ResourceEventHandler {
+	observe := func(obj interface{}) {
+		if pod, ok := obj.(*corev1.Pod); ok {
+			h.leases.observe(pod)
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    observe,
+		UpdateFunc: func(_, obj interface{}) { observe(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				h.leases.forget(pod)
+			}
+		},
+	}
+}
+
+// SetPodsSynced sets how to tell whether the pod event handler has received every existing
+// pod, such as the HasSynced of its registration. Until it reports true, network evidence
+// on IPs without recorded leases is accepted.
+func (h *CrossplaneHandler) SetPodsSynced(synced func() bool) {
+	h.leases.mu.Lock()
+	defer h.leases.mu.Unlock()
+	h.leases.synced = synced
+}
+
+// ipObservations records since when each cached resource has held each of its IPs
+type ipObservations struct {
+	mu    sync.Mutex
+	since map[string]map[string]time.Time
+}
+
+// newIPObservations creates an empty observation record
+func newIPObservations() *ipObservations {
+	return &ipObservations{since: make(map[string]map[string]time.Time)}
+}
+
+// record notes the IPs a resource holds now, keeping the first time each was seen
+func (o *ipObservations) record(uid string, ips []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(ips) == 0 {
+		delete(o.since, uid)
+		return
+	}
+
+	now := time.Now()
+	since := make(map[string]time.Time, len(ips))
+	for _, ip := range ips {
+		if seen, ok := o.since[uid][ip]; ok {
+			since[ip] = seen
+		} else {
+			since[ip] = now
+		}
+	}
+	o.since[uid] = since
+}
+
+// firstSeen returns since when a resource has held an IP
+func (o *ipObservations) firstSeen(uid, ip string) (time.Time, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	seen, ok := o.since[uid][ip]
+	return seen, ok
+}
+
+// ipObservationHandler keeps the IP observations of cached resources up to date
+func (h *CrossplaneHandler) ipObservationHandler() cache.ResourceEventHandler {
+	record := func(obj interface{}) {
+		if resource, ok := obj.(*unstructured.Unstructured); ok {
+			h.observations.record(string(resource.GetUID()), h.extractIPAddresses(resource))
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    record,
+		UpdateFunc: func(_, obj interface{}) { record(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if resource, ok := obj.(*unstructured.Unstructured); ok {
+				h.observations.record(string(resource.GetUID()), nil)
+			}
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package crossplane
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func testPod(namespace, name, ip string, started time.Time) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(namespace + "/" + name)},
+		Status: corev1.PodStatus{
+			Phase:     corev1.PodRunning,
+			PodIP:     ip,
+			StartTime: &metav1.Time{Time: started},
+		},
+	}
+}
+
+// TestHeldByReusedIP checks that a snapshot taken while one pod held an IP is not credited
+// to the namespace of a later pod that received the same IP
+func TestHeldByReusedIP(t *testing.T) {
+	const ip = "10.4.1.7"
+	now := time.Now()
+
+	leases := newIPLeaseHistory()
+	leases.synced = func() bool { return true }
+
+	earlier := testPod("team-a", "api", ip, now.Add(-3*time.Hour))
+	leases.observe(earlier)
+	leases.forget(earlier)
+	leases.observe(testPod("team-b", "worker", ip, now.Add(-time.Hour)))
+
+	// A resource observed with the IP while the earlier pod held it
+	from, to := now.Add(-150*time.Minute), now.Add(-2*time.Hour)
+	if !leases.heldBy(ip, "team-a", from, to) {
+		t.Errorf("earlier holder team-a does not match its own snapshot")
+	}
+	if leases.heldBy(ip, "team-b", from, to) {
+		t.Errorf("later holder team-b matches a snapshot taken before it received the IP")
+	}
+
+	// A resource observed with the IP since the later pod received it
+	from, to = time.Now(), time.Now().Add(time.Minute)
+	if leases.heldBy(ip, "team-a", from, to) {
+		t.Errorf("earlier holder team-a matches a snapshot taken after it released the IP")
+	}
+	if !leases.heldBy(ip, "team-b", from, to) {
+		t.Errorf("later holder team-b does not match its own snapshot")
+	}
+}
+
+// TestHeldByWithoutLeases checks that IPs without leases are only accepted until the pods
+// have been observed
+func TestHeldByWithoutLeases(t *testing.T) {
+	now := time.Now()
+	leases := newIPLeaseHistory()
+
+	if !leases.heldBy("10.4.1.7", "team-a", now.Add(-time.Hour), now) {
+		t.Errorf("IP without leases rejected before the pods are known")
+	}
+
+	synced := false
+	leases.synced = func() bool { return synced }
+	if !leases.heldBy("10.4.1.7", "team-a", now.Add(-time.Hour), now) {
+		t.Errorf("IP without leases rejected while the pods are being observed")
+	}
+
+	synced = true
+	if leases.heldBy("10.4.1.7", "team-a", now.Add(-time.Hour), now) {
+		t.Errorf("IP without leases accepted once every pod has been observed")
+	}
+}
@@ -0,0 +1,114 @@
+package crossplane
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Confidence of the metadata signals
+const (
+	nameConfidence           = 0.8
+	namespaceLabelConfidence = 0.9
+	environmentConfidence    = 0.7
+	labelValueConfidence     = 0.6
+	specFieldConfidence      = 0.5
+)
+
+// detectName matches a resource whose name contains the namespace name
+func detectName(resource *unstructured.Unstructured, nsCtx *NamespaceContext) []Evidence {
+	namespace := nsCtx.Namespace
+	if !strings.Contains(strings.ToLower(resource.GetName()), strings.ToLower(namespace)) {
+		return nil
+	}
+	return []Evidence{{
+		Resource:   *resource,
+		Confidence: nameConfidence,
+		Reason:     fmt.Sprintf("Resource name contains namespace: %s", namespace),
+	}}
+}
+
+// detectLabels matches the namespace in the Kubernetes labels of a resource and in the
+// namespace labels of spec.forProvider.labels. Ignored label keys are left out.
+func detectLabels(resource *unstructured.Unstructured, nsCtx *NamespaceContext, ignored map[string]bool) []Evidence {
+	namespace := nsCtx.Namespace
+	var evidence []Evidence
+	add := func(confidence float64, reason string) {
+		evidence = append(evidence, Evidence{Resource: *resource, Confidence: confidence, Reason: reason})
+	}
+
+	// Both label maps are copies, so ignored keys can be removed from them
+	if labels := resource.GetLabels(); labels != nil {
+		for key := range ignored {
+			delete(labels, key)
+		}
+
+		// Direct namespace label match (strongest indicator)
+		if ns, ok := labels["kubernetes-namespace"]; ok && ns == namespace {
+			add(namespaceLabelConfidence, "Resource has 'kubernetes-namespace' label matching the namespace")
+		}
+
+		// Alternative namespace label match
+		if ns, ok := labels["namespace"]; ok && ns == namespace {
+			add(namespaceLabelConfidence, "Resource has 'namespace' label matching the namespace")
+		}
+
+		// Environment label might indicate namespace
+		if env, ok := labels["environment"]; ok && env == namespace {
+			add(environmentConfidence, "Resource has 'environment' label matching the namespace")
+		}
+
+		// Check if any label contains the namespace name
+		for key, value := range labels {
+			if key != "kubernetes-namespace" && key != "namespace" && key != "environment" &&
+				strings.Contains(strings.ToLower(value), strings.ToLower(namespace)) {
+				evidence = append(evidence, Evidence{
+					Resource:   *resource,
+					Confidence: labelValueConfidence,
+					Reason:     fmt.Sprintf("Resource has label '%s' with value containing namespace", key),
+					Family:     FamilyLabelValue,
+				})
+			}
+		}
+	}
+
+	// Check labels within forProvider
+	if labels, ok, _ := unstructured.NestedMap(resource.Object, "spec", "forProvider", "labels"); ok {
+		for key := range ignored {
+			delete(labels, key)
+		}
+		if ns, ok := labels["kubernetes-namespace"].(string); ok && ns == namespace {
+			add(namespaceLabelConfidence, "Resource spec has 'kubernetes-namespace' label in forProvider.labels")
+		}
+		if ns, ok := labels["namespace"].(string); ok && ns == namespace {
+			add(namespaceLabelConfidence, "Resource spec has 'namespace' label in forProvider.labels")
+		}
+		if env, ok := labels["environment"].(string); ok && env == namespace {
+			add(environmentConfidence, "Resource spec has 'environment' label in forProvider.labels")
+		}
+	}
+
+	return evidence
+}
+
+// detectSpecFields matches the string fields of spec.forProvider that contain the
+// namespace name
+func detectSpecFields(resource *unstructured.Unstructured, nsCtx *NamespaceContext) []Evidence {
+	forProvider, ok, _ := unstructured.NestedMap(resource.Object, "spec", "forProvider")
+	if !ok {
+		return nil
+	}
+
+	var evidence []Evidence
+	for key, value := range forProvider {
+		if strValue, ok := value.(string); ok && strings.Contains(strings.ToLower(strValue), strings.ToLower(nsCtx.Namespace)) {
+			evidence = append(evidence, Evidence{
+				Resource:   *resource,
+				Confidence: specFieldConfidence,
+				Reason:     fmt.Sprintf("Resource spec.forProvider.%s contains namespace", key),
+			})
+		}
+	}
+	return evidence
+}
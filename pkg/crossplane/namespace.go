@@ -0,0 +1,183 @@
+package crossplane
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// Reasons a pod is left out of network matching
+const (
+	// PodExcludedHostNetwork marks pods sharing the network namespace of their node
+	PodExcludedHostNetwork = "HostNetwork"
+	// PodExcludedTerminal marks pods that succeeded or failed and hold no IP anymore
+	PodExcludedTerminal = "Terminal"
+	// PodExcludedNodeIP marks pods reporting the IP of a node
+	PodExcludedNodeIP = "NodeIP"
+)
+
+// NamespaceContext is the workload state of a namespace that resources are matched against
+type NamespaceContext struct {
+	// Namespace is the name of the namespace
+	Namespace string
+	// Pods are the selected pods of the namespace
+	Pods []corev1.Pod
+	// ServiceAccounts are the Kubernetes service accounts of the namespace
+	ServiceAccounts []corev1.ServiceAccount
+	// PersistentVolumeClaims are the persistent volume claims of the namespace
+	PersistentVolumeClaims []corev1.PersistentVolumeClaim
+	// PersistentVolumes are the persistent volumes bound to the claims
+	PersistentVolumes []corev1.PersistentVolume
+	// Services are the services of the namespace
+	Services []corev1.Service
+	// Ingresses are the ingresses of the namespace
+	Ingresses []networkingv1.Ingress
+	// ConfigMaps are the ConfigMaps of the namespace
+	ConfigMaps []corev1.ConfigMap
+	// NodeIPs are the addresses of the cluster's nodes, which never identify a namespace
+	NodeIPs []string
+}
+
+// podIPs returns the IPs a pod reports
+func podIPs(pod *corev1.Pod) []string {
+	var ips []string
+	for _, podIP := range pod.Status.PodIPs {
+		ips = append(ips, podIP.IP)
+	}
+	if pod.Status.PodIP != "" && len(pod.Status.PodIPs) == 0 {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	return ips
+}
+
+// podExclusion returns why a pod is left out of network matching: hostNetwork pods and pods
+// reporting a node IP would match the node's instance from every namespace, and terminal
+// pods no longer hold their IP
+func podExclusion(pod *corev1.Pod, nodeIPs map[string]bool) (string, bool) {
+	if pod.Spec.HostNetwork {
+		return PodExcludedHostNetwork, true
+	}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return PodExcludedTerminal, true
+	}
+	for _, ip := range canonicalIPs(podIPs(pod)) {
+		if nodeIPs[ip] {
+			return PodExcludedNodeIP, true
+		}
+	}
+	return "", false
+}
+
+// nodeIPSet returns the node IPs in canonical form
+func (c *NamespaceContext) nodeIPSet() map[string]bool {
+	nodeIPs := make(map[string]bool, len(c.NodeIPs))
+	for _, ip := range canonicalIPs(c.NodeIPs) {
+		nodeIPs[ip] = true
+	}
+	return nodeIPs
+}
+
+// PodIPs returns the IPs of the pods in the namespace context that take part in network
+// matching
+func (c *NamespaceContext) PodIPs() []string {
+	nodeIPs := c.nodeIPSet()
+	var ips []string
+	for i := range c.Pods {
+		if _, excluded := podExclusion(&c.Pods[i], nodeIPs); excluded {
+			continue
+		}
+		ips = append(ips, podIPs(&c.Pods[i])...)
+	}
+	return ips
+}
+
+// ExcludedPods counts the pods left out of network matching, by reason
+func (c *NamespaceContext) ExcludedPods() map[string]int {
+	nodeIPs := c.nodeIPSet()
+	excluded := make(map[string]int)
+	for i := range c.Pods {
+		if reason, ok := podExclusion(&c.Pods[i], nodeIPs); ok {
+			excluded[reason]++
+		}
+	}
+	return excluded
+}
+
+// FindCrossplaneResourcesForNamespaceContext finds the resources associated with a
+// namespace. Each enabled detector of the options reports evidence, such as a resource name
+// containing the namespace, a pod IP held by the resource, or a connection Secret its pods
+// consume. The evidence for a resource is combined as independent signals, see
+// combineConfidence, and the resource is a match when the combined confidence exceeds the
+// threshold. Resources composed for a claim in another namespace are never matched.
+func (h *CrossplaneHandler) FindCrossplaneResourcesForNamespaceContext(
+	ctx context.Context,
+	nsCtx *NamespaceContext,
+	opts FindOptions,
+) ([]ResourceMatch, error) {
+	if h.mockMode {
+		log.Info("Mock mode: Finding Crossplane resources for namespace", "namespace", nsCtx.Namespace)
+		return []ResourceMatch{}, nil
+	}
+
+	req := &DetectionRequest{
+		Namespace:     nsCtx,
+		ResourceTypes: h.ManagedResourceTypes(ctx, opts.ResourceTypes),
+		IgnoredLabels: opts.ignoredLabels(),
+		handler:       h,
+	}
+
+	var candidates []ResourceMatch
+	var scores [][]scoredEvidence
+	found := make(map[string]int)
+	for _, detector := range opts.detectors().Detectors() {
+		weight, enabled := opts.detectorWeight(detector.Name())
+		if !enabled {
+			continue
+		}
+		for _, evidence := range detector.Detect(ctx, req) {
+			key := matchKey(&evidence.Resource)
+			i, ok := found[key]
+			if !ok {
+				i = len(candidates)
+				found[key] = i
+				candidates = append(candidates, ResourceMatch{Resource: evidence.Resource})
+				scores = append(scores, nil)
+			}
+			candidates[i].MatchReasons = append(candidates[i].MatchReasons, evidence.Reason)
+
+			family := evidence.Family
+			if family == "" {
+				family = detector.Name()
+			}
+			scores[i] = append(scores[i], scoredEvidence{family: family, confidence: evidence.Confidence, weight: weight})
+		}
+	}
+	for i := range candidates {
+		candidates[i].ConfidenceScore = combineConfidence(scores[i])
+	}
+
+	threshold := opts.threshold()
+	var matches []ResourceMatch
+	for _, match := range candidates {
+		if match.ConfidenceScore <= threshold {
+			continue
+		}
+		if _, ok, claimed := h.claimMatch(ctx, &match.Resource, nsCtx.Namespace); claimed && !ok {
+			continue
+		}
+
+		log.V(1).Info("Found resource for namespace",
+			"resource", resourceRef(&match.Resource),
+			"namespace", nsCtx.Namespace,
+			"confidence", match.ConfidenceScore,
+			"reasons", strings.Join(match.MatchReasons, ", "))
+		matches = append(matches, match)
+	}
+
+	sortMatchesByConfidence(matches)
+
+	log.Info("Found resources for namespace", "namespace", nsCtx.Namespace, "count", len(matches))
+	return matches, nil
+}
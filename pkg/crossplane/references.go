@@ -0,0 +1,243 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// identifierIndex indexes resources by their external name and the well-known
+	// identifiers workloads use to reach them
+	identifierIndex = "identifier"
+
+	// referenceConfidence is the confidence of a match backed by a workload naming a resource
+	// identifier exactly in its env, ConfigMaps or args. It ranks above name substring matches.
+	referenceConfidence = 0.85
+
+	// minIdentifierLength is the length below which identifiers are too ambiguous to match
+	minIdentifierLength = 4
+)
+
+// identifierFields are status.atProvider fields holding identifiers workloads are configured
+// with, such as the Cloud SQL instance connection name or the path of a topic
+var identifierFields = []string{"connectionName", "id", "url"}
+
+// genericIdentifiers are external names too common in configuration to attribute resources by
+var genericIdentifiers = map[string]bool{
+	"default": true,
+	"enabled": true,
+	"false":   true,
+	"none":    true,
+	"null":    true,
+	"true":    true,
+}
+
+// resourceIdentifiers returns the values a workload may be configured with to reach a
+// resource: its external name and well-known identifiers from its status
+func resourceIdentifiers(resource *unstructured.Unstructured) []string {
+	var identifiers []string
+	if externalName := resource.GetAnnotations()[ExternalNameAnnotation]; externalName != "" {
+		identifiers = append(identifiers, externalName)
+	}
+	for _, field := range identifierFields {
+		if value, _, _ := unstructured.NestedString(resource.Object, "status", "atProvider", field); value != "" {
+			identifiers = append(identifiers, value)
+		}
+	}
+
+	usable := identifiers[:0]
+	for _, identifier := range identifiers {
+		if len(identifier) >= minIdentifierLength && !genericIdentifiers[strings.ToLower(identifier)] {
+			usable = append(usable, identifier)
+		}
+	}
+	return dedupe(usable)
+}
+
+// referenceCandidates splits a configuration value into the values that may name a
+// resource: the value itself, its comma, semicolon and whitespace separated parts, the
+// value of key=value parts, and the bucket of gs:// URLs
+func referenceCandidates(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+
+	candidates := []string{value}
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		if _, v, ok := strings.Cut(part, "="); ok {
+			part = v
+		}
+		part = strings.Trim(part, `"'`)
+		candidates = append(candidates, part)
+		if bucket, ok := strings.CutPrefix(part, "gs://"); ok {
+			bucket, _, _ = strings.Cut(bucket, "/")
+			candidates = append(candidates, bucket)
+		}
+	}
+	return dedupe(candidates)
+}
+
+// podReferences returns the configuration values of a pod that may name a resource, mapped
+// to where they were found: literal env vars, the ConfigMap keys it consumes through env,
+// envFrom and volumes, and container args and commands
+func podReferences(pod *corev1.Pod, configMaps map[string]*corev1.ConfigMap) map[string]string {
+	refs := make(map[string]string)
+	add := func(value, where string) {
+		for _, candidate := range referenceCandidates(value) {
+			if _, ok := refs[candidate]; !ok {
+				refs[candidate] = where
+			}
+		}
+	}
+	addConfigMap := func(name, how string) {
+		configMap, ok := configMaps[name]
+		if !ok {
+			return
+		}
+		keys := make([]string, 0, len(configMap.Data))
+		for key := range configMap.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			add(configMap.Data[key], fmt.Sprintf("key %s of ConfigMap %s (%s)", key, name, how))
+		}
+	}
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.Value != "" {
+				add(env.Value, fmt.Sprintf("env %s in container %s", env.Name, container.Name))
+				continue
+			}
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil {
+				ref := env.ValueFrom.ConfigMapKeyRef
+				if configMap, ok := configMaps[ref.Name]; ok {
+					add(configMap.Data[ref.Key], fmt.Sprintf("key %s of ConfigMap %s (env %s in container %s)", ref.Key, ref.Name, env.Name, container.Name))
+				}
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				addConfigMap(envFrom.ConfigMapRef.Name, fmt.Sprintf("envFrom in container %s", container.Name))
+			}
+		}
+		for _, arg := range append(append([]string{}, container.Command...), container.Args...) {
+			add(arg, fmt.Sprintf("args of container %s", container.Name))
+		}
+	}
+
+	for _, volume := range pod.Spec.Volumes {
+		if volume.ConfigMap != nil {
+			addConfigMap(volume.ConfigMap.Name, fmt.Sprintf("volume %s", volume.Name))
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					addConfigMap(source.ConfigMap.Name, fmt.Sprintf("projected volume %s", volume.Name))
+				}
+			}
+		}
+	}
+
+	return refs
+}
+
+// findConfigurationReferences returns evidence for the resources the workloads of a namespace
+// name exactly in their env, the ConfigMaps they consume, or their container args
+func (h *CrossplaneHandler) findConfigurationReferences(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	nsCtx *NamespaceContext,
+) []Evidence {
+	configMaps := make(map[string]*corev1.ConfigMap, len(nsCtx.ConfigMaps))
+	for i := range nsCtx.ConfigMaps {
+		configMaps[nsCtx.ConfigMaps[i].Name] = &nsCtx.ConfigMaps[i]
+	}
+
+	// Keep the first pod and place naming each value
+	references := make(map[string]string)
+	for i := range nsCtx.Pods {
+		pod := &nsCtx.Pods[i]
+		for value, where := range podReferences(pod, configMaps) {
+			if _, ok := references[value]; !ok && len(value) >= minIdentifierLength {
+				references[value] = fmt.Sprintf("Pod %s names %s in %s", pod.Name, value, where)
+			}
+		}
+	}
+	if len(references) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(references))
+	for value := range references {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	named := h.resourcesByIdentifier(ctx, resourceTypes, values)
+
+	var evidence []Evidence
+	for _, value := range values {
+		for _, resource := range named[value] {
+			evidence = append(evidence, Evidence{
+				Resource:   resource,
+				Confidence: referenceConfidence,
+				Reason:     fmt.Sprintf("%s, identifying %s %s", references[value], resource.GetKind(), resource.GetName()),
+			})
+		}
+	}
+	return evidence
+}
+
+// resourcesByIdentifier returns the resources of the given types identified by each of the
+// values, looked up in the cache's identifier index for the types that are cached and
+// scanned for the others
+func (h *CrossplaneHandler) resourcesByIdentifier(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	values []string,
+) map[string][]unstructured.Unstructured {
+	cached, uncached := h.cache.partition(resourceTypes)
+	named := h.scanResourcesByIdentifier(ctx, uncached, values)
+	for _, value := range values {
+		if resources := h.cache.byIndex(cached, identifierIndex, value); len(resources) > 0 {
+			named[value] = append(named[value], resources...)
+		}
+	}
+	return named
+}
+
+// scanResourcesByIdentifier finds the resources identified by the values by scanning every
+// resource of types that are not cached yet
+func (h *CrossplaneHandler) scanResourcesByIdentifier(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	values []string,
+) map[string][]unstructured.Unstructured {
+	wanted := make(map[string]bool, len(values))
+	for _, value := range values {
+		wanted[value] = true
+	}
+
+	named := make(map[string][]unstructured.Unstructured)
+	for _, t := range resourceTypes {
+		for _, resource := range h.listNamespaced(ctx, t.GVR, "") {
+			for _, identifier := range resourceIdentifiers(&resource) {
+				if wanted[identifier] {
+					named[identifier] = append(named[identifier], resource)
+				}
+			}
+		}
+	}
+	return named
+}
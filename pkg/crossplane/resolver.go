@@ -0,0 +1,177 @@
+package crossplane
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultResolverTTL is how long answers are cached when the resolver does not report a TTL
+	defaultResolverTTL = 5 * time.Minute
+
+	// negativeResolverTTL is how long failed lookups are cached
+	negativeResolverTTL = 30 * time.Second
+
+	// resolveTimeout bounds a single lookup
+	resolveTimeout = 2 * time.Second
+
+	// resolvedEndpointsInterval is how long the resolved endpoint hostnames are reused, so a
+	// reconcile resolves each hostname once rather than once per pod IP and flow destination
+	resolvedEndpointsInterval = time.Minute
+)
+
+// Resolver resolves hostnames found in resource endpoints to IP addresses
+type Resolver interface {
+	// Resolve returns the addresses of a hostname and how long they may be cached. A zero
+	// TTL means the resolver does not know it.
+	Resolve(ctx context.Context, host string) ([]netip.Addr, time.Duration, error)
+}
+
+// systemResolver resolves hostnames with the Go resolver, which does not report TTLs
+type systemResolver struct {
+	resolver *net.Resolver
+}
+
+// Resolve implements Resolver
+func (r systemResolver) Resolve(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	addrs, err := r.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, 0, nil
+}
+
+// resolverEntry is a cached lookup
+type resolverEntry struct {
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+// CachingResolver caches the answers of another resolver for their TTL, or for a default
+// TTL when the resolver does not report one. Failed lookups are cached briefly so an
+// unresolvable endpoint is not looked up on every reconcile.
+type CachingResolver struct {
+	next    Resolver
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]resolverEntry
+}
+
+// NewCachingResolver creates a caching resolver in front of another resolver. A nil
+// resolver uses the system resolver.
+func NewCachingResolver(next Resolver) *CachingResolver {
+	if next == nil {
+		next = systemResolver{resolver: net.DefaultResolver}
+	}
+	return &CachingResolver{next: next, now: time.Now, entries: make(map[string]resolverEntry)}
+}
+
+// Resolve implements Resolver, returning the remaining TTL of cached answers
+func (r *CachingResolver) Resolve(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	now := r.now()
+
+	r.mu.Lock()
+	entry, ok := r.entries[host]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.addrs, entry.expires.Sub(now), entry.err
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	addrs, ttl, err := r.next.Resolve(lookupCtx, host)
+	switch {
+	case err != nil:
+		ttl = negativeResolverTTL
+	case ttl <= 0:
+		ttl = defaultResolverTTL
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for cached, e := range r.entries {
+		if now.After(e.expires) {
+			delete(r.entries, cached)
+		}
+	}
+	r.entries[host] = resolverEntry{addrs: addrs, err: err, expires: now.Add(ttl)}
+	return addrs, ttl, err
+}
+
+// resolvedEndpoints maps private IPs back to the endpoint hostnames resolving to them
+type resolvedEndpoints struct {
+	built    time.Time
+	resolved map[string]bool
+	hosts    map[string][]string
+}
+
+// SetResolver replaces the resolver used for hostnames in resource endpoints, for example
+// with a fake in tests. The default is a CachingResolver over the system resolver.
+func (h *CrossplaneHandler) SetResolver(resolver Resolver) {
+	h.resolverMu.Lock()
+	h.resolver = resolver
+	h.resolverMu.Unlock()
+
+	h.endpointsMu.Lock()
+	defer h.endpointsMu.Unlock()
+	h.endpoints = nil
+}
+
+// hostnamesForIP returns the endpoint hostnames of the cached resources of the given types
+// that resolve to an IP. Hostnames are resolved into a snapshot that is reused for
+// resolvedEndpointsInterval, and looking an IP up only resolves hostnames new to it.
+func (h *CrossplaneHandler) hostnamesForIP(ctx context.Context, resourceTypes []ManagedResourceType, ip string) []string {
+	h.endpointsMu.Lock()
+	defer h.endpointsMu.Unlock()
+
+	if h.endpoints == nil || time.Since(h.endpoints.built) > resolvedEndpointsInterval {
+		h.endpoints = &resolvedEndpoints{
+			built:    time.Now(),
+			resolved: make(map[string]bool),
+			hosts:    make(map[string][]string),
+		}
+	}
+	for _, host := range h.cache.indexValues(resourceTypes, hostnameIndex) {
+		if h.endpoints.resolved[host] {
+			continue
+		}
+		h.endpoints.resolved[host] = true
+		for _, addr := range h.resolvePrivate(ctx, host) {
+			h.endpoints.hosts[addr] = append(h.endpoints.hosts[addr], host)
+		}
+	}
+	return h.endpoints.hosts[ip]
+}
+
+// resolvePrivate resolves a hostname to its private addresses. Public addresses are left
+// out: they belong to shared frontends, such as those of Google APIs and run.app, and
+// would attribute every resource behind them to anyone talking to one of them.
+func (h *CrossplaneHandler) resolvePrivate(ctx context.Context, host string) []string {
+	h.resolverMu.Lock()
+	resolver := h.resolver
+	h.resolverMu.Unlock()
+
+	addrs, _, err := resolver.Resolve(ctx, host)
+	if err != nil {
+		log.V(1).Info("Failed to resolve resource hostname", "host", host, "error", err.Error())
+		return nil
+	}
+
+	var ips []string
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if addr.IsPrivate() {
+			ips = append(ips, addr.String())
+		}
+	}
+	return dedupe(ips)
+}
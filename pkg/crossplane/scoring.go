@@ -0,0 +1,64 @@
+package crossplane
+
+import "math"
+
+// FamilyLabelValue is the family of labels whose value merely contains the namespace name.
+// It is capped apart from the namespace labels of the label detector, so many such labels
+// cannot add up to a namespace label.
+const FamilyLabelValue = "label-value"
+
+// defaultFamilyCap caps the families without an entry in familyCaps. Evidence inferred
+// from workloads is never certain on its own.
+const defaultFamilyCap = 0.99
+
+// familyCaps caps the combined confidence of a signal family. Repeated hits of the same
+// weak signal, such as a namespace name found in several spec fields, are not independent
+// and cannot outweigh a single strong signal.
+var familyCaps = map[string]float64{
+	DetectorClaim:     ClaimConfidence,
+	DetectorName:      nameConfidence,
+	FamilyLabelValue:  0.7,
+	DetectorSpecField: 0.6,
+}
+
+// scoredEvidence is the confidence of one piece of evidence, the family it counts towards
+// and the weight of the detector that found it
+type scoredEvidence struct {
+	family     string
+	confidence float64
+	weight     float64
+}
+
+// combineConfidence combines the evidence for a resource with noisy-OR: the resource is
+// unrelated to the namespace only if every piece of evidence is wrong, so the combined
+// confidence is 1 - (1-c1)(1-c2)... and more evidence never lowers it. Evidence is first
+// combined within its family and capped, then weighted, and the families are combined.
+func combineConfidence(evidence []scoredEvidence) float64 {
+	var families []string
+	missed := make(map[string]float64)
+	weights := make(map[string]float64)
+	for _, e := range evidence {
+		if _, ok := missed[e.family]; !ok {
+			families = append(families, e.family)
+			missed[e.family] = 1
+			weights[e.family] = e.weight
+		}
+		missed[e.family] *= 1 - clampConfidence(e.confidence)
+	}
+
+	combinedMissed := 1.0
+	for _, family := range families {
+		familyCap, ok := familyCaps[family]
+		if !ok {
+			familyCap = defaultFamilyCap
+		}
+		confidence := math.Min(1-missed[family], familyCap)
+		combinedMissed *= 1 - clampConfidence(confidence*weights[family])
+	}
+	return 1 - combinedMissed
+}
+
+// clampConfidence limits a confidence to the range from 0 to 1
+func clampConfidence(confidence float64) float64 {
+	return math.Max(0, math.Min(confidence, 1))
+}
@@ -0,0 +1,111 @@
+package crossplane
+
+import (
+	"math"
+	"testing"
+)
+
+// TestCombineConfidence documents example scores. Keep docs/configuration.md in sync.
+func TestCombineConfidence(t *testing.T) {
+	hits := func(family string, confidence float64, n int) []scoredEvidence {
+		evidence := make([]scoredEvidence, n)
+		for i := range evidence {
+			evidence[i] = scoredEvidence{family: family, confidence: confidence, weight: 1}
+		}
+		return evidence
+	}
+	with := func(groups ...[]scoredEvidence) []scoredEvidence {
+		var evidence []scoredEvidence
+		for _, group := range groups {
+			evidence = append(evidence, group...)
+		}
+		return evidence
+	}
+
+	tests := []struct {
+		name     string
+		evidence []scoredEvidence
+		want     float64
+	}{
+		{
+			name: "no evidence",
+			want: 0,
+		},
+		{
+			name:     "name contains namespace",
+			evidence: hits(DetectorName, 0.8, 1),
+			want:     0.8,
+		},
+		{
+			name:     "namespace label",
+			evidence: hits(DetectorLabel, 0.9, 1),
+			want:     0.9,
+		},
+		{
+			name:     "a weak spec field corroborates a namespace label",
+			evidence: with(hits(DetectorLabel, 0.9, 1), hits(DetectorSpecField, 0.5, 1)),
+			want:     0.95,
+		},
+		{
+			name:     "name and namespace label",
+			evidence: with(hits(DetectorName, 0.8, 1), hits(DetectorLabel, 0.9, 1)),
+			want:     0.98,
+		},
+		{
+			name:     "ten label values containing the namespace are capped",
+			evidence: hits(FamilyLabelValue, 0.6, 10),
+			want:     0.7,
+		},
+		{
+			name:     "three spec fields containing the namespace are capped",
+			evidence: hits(DetectorSpecField, 0.5, 3),
+			want:     0.6,
+		},
+		{
+			name:     "label value and name",
+			evidence: with(hits(FamilyLabelValue, 0.6, 1), hits(DetectorName, 0.8, 1)),
+			want:     0.92,
+		},
+		{
+			name:     "two pod IPs held by the resource",
+			evidence: hits(DetectorNetwork, 0.9, 2),
+			want:     0.99,
+		},
+		{
+			name:     "dedicated subnetwork range and light traffic",
+			evidence: with(hits(DetectorSubnet, 0.75, 1), hits(DetectorFlow, 0.6, 1)),
+			want:     0.9,
+		},
+		{
+			name:     "claim",
+			evidence: with(hits(DetectorClaim, ClaimConfidence, 1), hits(DetectorSpecField, 0.5, 1)),
+			want:     1,
+		},
+		{
+			name:     "spec field at half weight falls below the default threshold",
+			evidence: []scoredEvidence{{family: DetectorSpecField, confidence: 0.5, weight: 0.5}},
+			want:     0.25,
+		},
+		{
+			name:     "weight applies after the family cap",
+			evidence: []scoredEvidence{{family: DetectorName, confidence: 0.8, weight: 1.2}},
+			want:     0.96,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := combineConfidence(tt.evidence)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("combineConfidence() = %v, want %v", got, tt.want)
+			}
+
+			// More evidence never lowers the confidence
+			for n := 0; n < len(tt.evidence); n++ {
+				if partial := combineConfidence(tt.evidence[:n]); partial > got+1e-9 {
+					t.Errorf("confidence of the first %d pieces of evidence %v exceeds %v", n, partial, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package crossplane
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// subnetConfidence is the confidence of a match backed by a pod IP inside a range of a
+	// subnetwork dedicated to the namespace. It stays below the confidence of direct
+	// network connections.
+	subnetConfidence = 0.75
+
+	// sharedSubnetConfidence is the confidence of a match backed by a pod IP inside a range
+	// that other namespaces use too, such as the cluster-wide pod range. It stays below the
+	// default threshold, so a shared range only corroborates other evidence.
+	sharedSubnetConfidence = 0.2
+)
+
+// subnetRange is an IP range of a subnetwork: its primary range, a secondary range or its
+// IPv6 range
+type subnetRange struct {
+	resource  unstructured.Unstructured
+	rangeName string
+	prefix    netip.Prefix
+}
+
+// subnetRanges returns the IPv4 and IPv6 ranges of a Subnetwork managed resource
+func subnetRanges(resource *unstructured.Unstructured) []subnetRange {
+	var ranges []subnetRange
+	add := func(rangeName, cidr string) {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil || prefix.Addr().Is4In6() {
+			return
+		}
+		ranges = append(ranges, subnetRange{resource: *resource, rangeName: rangeName, prefix: prefix.Masked()})
+	}
+
+	if cidr, _, _ := unstructured.NestedString(resource.Object, "spec", "forProvider", "ipCidrRange"); cidr != "" {
+		add("primary", cidr)
+	}
+	secondary, _, _ := unstructured.NestedSlice(resource.Object, "spec", "forProvider", "secondaryIpRange")
+	for _, item := range secondary {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := itemMap["rangeName"].(string)
+		if cidr, _ := itemMap["ipCidrRange"].(string); cidr != "" {
+			add(name, cidr)
+		}
+	}
+	for _, field := range []string{"ipv6CidrRange", "externalIpv6Prefix"} {
+		if cidr, _, _ := unstructured.NestedString(resource.Object, "status", "atProvider", field); cidr != "" {
+			add("ipv6", cidr)
+		}
+	}
+	return ranges
+}
+
+// prefixTrie is a binary trie of IP prefixes, with separate roots for IPv4 and IPv6, that
+// finds every range containing an address in one walk of its bits
+type prefixTrie struct {
+	v4 *trieNode
+	v6 *trieNode
+}
+
+// trieNode is a node of a prefix trie, holding the ranges whose prefix ends at it
+type trieNode struct {
+	children [2]*trieNode
+	ranges   []subnetRange
+}
+
+// newPrefixTrie creates an empty prefix trie
+func newPrefixTrie() *prefixTrie {
+	return &prefixTrie{v4: &trieNode{}, v6: &trieNode{}}
+}
+
+// root returns the root for the family of an address
+func (t *prefixTrie) root(addr netip.Addr) *trieNode {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+// insert adds a range to the trie
+func (t *prefixTrie) insert(r subnetRange) {
+	addr := r.prefix.Addr()
+	bytes := addr.AsSlice()
+	node := t.root(addr)
+	for i := 0; i < r.prefix.Bits(); i++ {
+		bit := bytes[i/8] >> (7 - i%8) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.ranges = append(node.ranges, r)
+}
+
+// lookup returns the ranges containing an address, most specific first
+func (t *prefixTrie) lookup(addr netip.Addr) []subnetRange {
+	addr = addr.Unmap()
+	bytes := addr.AsSlice()
+	node := t.root(addr)
+
+	var ranges []subnetRange
+	for i := 0; node != nil; i++ {
+		ranges = append(ranges, node.ranges...)
+		if i == addr.BitLen() {
+			break
+		}
+		node = node.children[bytes[i/8]>>(7-i%8)&1]
+	}
+
+	// Ranges were collected from the shortest prefix down
+	for i, j := 0, len(ranges)-1; i < j; i, j = i+1, j-1 {
+		ranges[i], ranges[j] = ranges[j], ranges[i]
+	}
+	return ranges
+}
+
+// subnetTrie builds a prefix trie of the ranges of the subnetworks among the given types
+func (h *CrossplaneHandler) subnetTrie(ctx context.Context, resourceTypes []ManagedResourceType) *prefixTrie {
+	trie := newPrefixTrie()
+	for _, t := range resourceTypes {
+		if t.GVR.Group != "compute.gcp.upbound.io" || t.Kind != "Subnetwork" {
+			continue
+		}
+		for _, resource := range h.listNamespaced(ctx, t.GVR, "") {
+			for _, r := range subnetRanges(&resource) {
+				trie.insert(r)
+			}
+		}
+	}
+	return trie
+}
+
+// findSubnetworks returns evidence for the subnetworks whose primary, secondary or IPv6
+// ranges hold the IPs of the pods of a namespace. Only the most specific range containing
+// each IP counts, so a dedicated secondary range wins over the primary range around it.
+// A range is dedicated when every IP in use in it, according to the pod IP leases, is
+// held by a pod of the namespace; other ranges only give weak evidence.
+func (h *CrossplaneHandler) findSubnetworks(
+	ctx context.Context,
+	resourceTypes []ManagedResourceType,
+	nsCtx *NamespaceContext,
+) []Evidence {
+	podIPs := nsCtx.PodIPs()
+	if len(podIPs) == 0 {
+		return nil
+	}
+	trie := h.subnetTrie(ctx, resourceTypes)
+
+	// Keep the first pod IP found in each range
+	seen := make(map[string]bool)
+	var evidence []Evidence
+	for _, podIP := range podIPs {
+		addr, ok := parseIP(podIP)
+		if !ok {
+			continue
+		}
+		ranges := trie.lookup(addr)
+		if len(ranges) == 0 {
+			continue
+		}
+
+		r := ranges[0]
+		key := fmt.Sprintf("%s/%s", r.resource.GetUID(), r.prefix)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		confidence := subnetConfidence
+		reason := fmt.Sprintf("Pod IP %s is in %s range %s of Subnetwork %s, dedicated to the namespace",
+			addr, r.rangeName, r.prefix, r.resource.GetName())
+		namespaces := h.leases.namespacesIn(r.prefix)
+		if !namespaces[nsCtx.Namespace] {
+			// The leases have not caught up with the pods, so the range may be shared
+			confidence = sharedSubnetConfidence
+			reason = fmt.Sprintf("Pod IP %s is in %s range %s of Subnetwork %s, not known to be dedicated to the namespace",
+				addr, r.rangeName, r.prefix, r.resource.GetName())
+		} else if others := len(namespaces) - 1; others > 0 {
+			confidence = sharedSubnetConfidence
+			reason = fmt.Sprintf("Pod IP %s is in %s range %s of Subnetwork %s, shared with %d other namespaces",
+				addr, r.rangeName, r.prefix, r.resource.GetName(), others)
+		}
+		evidence = append(evidence, Evidence{
+			Resource:   r.resource,
+			Confidence: confidence,
+			Reason:     reason,
+		})
+	}
+	return evidence
+}